@@ -16,13 +16,27 @@ limitations under the License.
 package cmd
 
 import (
+	"cmp"
+	"context"
 	"fmt"
 	ext "github.com/linuxsuren/api-testing/pkg/extension"
+	"github.com/linuxsuren/api-testing/pkg/testing/remote"
+	"github.com/linuxsuren/api-testing/pkg/version"
 	"github.com/linuxsuren/atest-ext-store-terminal/pkg"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+// the environment variable of the config file, the --config flag overrides it
+const configEnv = "ATEST_TERMINAL_CONFIG"
+
 func NewRootCmd() (cmd *cobra.Command) {
 	opt := &option{
 		Extension: ext.NewExtension("terminal", "store", 4076),
@@ -32,10 +46,63 @@ func NewRootCmd() (cmd *cobra.Command) {
 		RunE: opt.runE,
 	}
 	opt.AddFlags(cmd.Flags())
-	cmd.Flags().IntVarP(&opt.serverPort, "server-port", "", 0, "the port of the server")
+	cmd.Flags().StringVarP(&opt.configFile, "config", "", os.Getenv(configEnv), "the YAML config file, the flags which are given override it. It's reloaded on SIGHUP or change")
+	addConfigFlags(cmd.Flags(), &opt.config)
+	cmd.AddCommand(newSeccompExecCmd())
 	return
 }
 
+// addConfigFlags adds the flags of the settings which are in the config file as well
+func addConfigFlags(flags *pflag.FlagSet, config *pkg.Config) {
+	flags.IntVarP(&config.Server.Port, "server-port", "", 0, "the port of the server")
+	flags.DurationVarP(&config.Server.ShutdownGrace, "shutdown-grace", "", 10*time.Second, "how long the running sessions and jobs are waited for on exit before they're killed")
+	flags.StringVarP(&config.Log.Level, "log-level", "", "info", "the level of the server logs: debug, info, warn or error")
+	flags.StringVarP(&config.Log.Format, "log-format", "", "text", "the format of the server logs: text or json")
+	flags.StringVarP(&config.SeccompProfileDir, "seccomp-profile-dir", "", "", "the directory of the seccomp profiles (*.json, *.yaml)")
+	flags.StringVarP(&config.Cgroup.Root, "cgroup-root", "", "", "the delegated cgroup v2 directory, each session gets its own sub-group when it is set")
+	flags.StringVarP(&config.Cgroup.Limits.MemoryMax, "cgroup-memory-max", "", "", "the memory.max of each session cgroup, for instance: 512M")
+	flags.StringVarP(&config.Cgroup.Limits.CPUMax, "cgroup-cpu-max", "", "", "the cpu.max of each session cgroup, for instance: 50000 100000")
+	flags.StringVarP(&config.Cgroup.Limits.PidsMax, "cgroup-pids-max", "", "", "the pids.max of each session cgroup")
+	flags.BoolVarP(&config.Env.Inherit, "env-inherit", "", false, "inherit the full environment of this process in the terminal sessions")
+	flags.StringSliceVarP(&config.Env.PassThrough, "env-pass", "", nil, "the environment variables passed through to the terminal sessions, for instance: LC_*")
+	flags.StringToStringVarP(&config.Env.Set, "env", "", nil, "the environment variables injected into the terminal sessions")
+	flags.StringSliceVarP(&config.Env.Secrets, "env-secret", "", nil, "the environment variables whose values are masked in the API responses")
+	flags.StringVarP(&config.ProfilesFile, "profiles", "", "", "the YAML file of the named session profiles")
+	flags.StringVarP(&config.Recording.Dir, "recording-dir", "", "", "the directory of the session recordings, recording is disabled if it is empty")
+	flags.BoolVarP(&config.Recording.Input, "recording-input", "", false, "record the input of the sessions as well")
+	flags.StringVarP(&config.StoreDir, "store-dir", "", "", "the directory of the command test suites, it's under the user config directory by default")
+	flags.StringVarP(&config.Jobs.Dir, "job-dir", "", "", "the directory of the background job logs, it's under the user cache directory by default")
+	flags.DurationVarP(&config.Jobs.Retention, "job-retention", "", 24*time.Hour, "how long the finished background jobs are kept")
+	flags.IntVarP(&config.Jobs.MaxJobs, "job-max", "", 100, "the number of the finished background jobs which are kept at most")
+	flags.DurationVarP(&config.Jobs.Timeout, "job-timeout", "", time.Hour, "the timeout of the background jobs which have no timeout of their own")
+	flags.IntVarP(&config.Limits.Commands, "max-commands", "", 0, "the number of the concurrent commands of /api/exec, zero means unlimited")
+	flags.IntVarP(&config.Limits.CommandsPerUser, "max-commands-per-user", "", 0, "the number of the concurrent commands of /api/exec per user")
+	flags.IntVarP(&config.Limits.Streams, "max-streams", "", 0, "the number of the concurrent streaming (SSE) commands")
+	flags.IntVarP(&config.Limits.StreamsPerUser, "max-streams-per-user", "", 0, "the number of the concurrent streaming (SSE) commands per user")
+	flags.IntVarP(&config.Limits.Sessions, "max-sessions", "", 0, "the number of the concurrent PTY sessions")
+	flags.IntVarP(&config.Limits.SessionsPerUser, "max-sessions-per-user", "", 0, "the number of the concurrent PTY sessions per user")
+	flags.IntVarP(&config.Limits.Queue, "max-queue", "", 100, "the number of the commands which wait for a free slot at most, zero means unlimited")
+	flags.StringVarP(&config.SchedulesFile, "schedules", "", "", "the YAML file of the scheduled commands, it's under the user config directory by default")
+	flags.StringVarP(&config.Hosts.File, "hosts", "", "", "the YAML file of the stored SSH hosts, it's under the user config directory by default")
+	flags.StringVarP(&config.Hosts.KeyFile, "hosts-key", "", "", "the key file which encrypts the credentials of the stored hosts, it's generated if it does not exist")
+	flags.StringVarP(&config.Hosts.KnownHostsFile, "known-hosts", "", "", "the known_hosts file of the stored hosts, it's managed by the server")
+}
+
+// newSeccompExecCmd is an internal command used to bring up the loopback
+// interface of an isolated command and to apply its seccomp profile before
+// executing it
+func newSeccompExecCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:                pkg.SeccompExecCommand,
+		Hidden:             true,
+		DisableFlagParsing: true,
+		SilenceUsage:       true,
+		RunE: func(_ *cobra.Command, args []string) error {
+			return pkg.RunSeccompExec(args)
+		},
+	}
+}
+
 func (o *option) runE(c *cobra.Command, args []string) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -43,13 +110,91 @@ func (o *option) runE(c *cobra.Command, args []string) (err error) {
 		}
 	}()
 
-	lis := pkg.StartExecServer(fmt.Sprintf(":%d", o.serverPort))
-	pkg.SetServerPort(lis.Addr().(*net.TCPAddr).Port)
-	err = ext.CreateRunner(o.Extension, c, pkg.NewRemoteServer(lis.Addr().(*net.TCPAddr).Port))
+	if o.config, err = o.loadConfig(); err != nil {
+		return
+	}
+	// the usage doesn't help once the flags are parsed
+	c.SilenceUsage = true
+	var server *pkg.ExecServer
+	if server, err = pkg.NewExecServer(pkg.WithConfig(o.config)); err != nil {
+		return
+	}
+	if o.configFile != "" {
+		server.WatchConfig(o.configFile, o.loadConfig)
+	}
+	go func() {
+		if err := server.Serve(); err != nil {
+			c.PrintErrln("exec server error:", err)
+		}
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), o.config.Server.ShutdownGrace)
+		defer cancel()
+		// the sessions and the jobs share the grace period, the exit status
+		// tells whether anything was killed
+		jobsErr := make(chan error, 1)
+		go func() {
+			jobsErr <- server.DrainJobs(ctx)
+		}()
+		sessionsErr := server.Shutdown(ctx)
+		if drainErr := cmp.Or(sessionsErr, <-jobsErr); drainErr != nil && err == nil {
+			err = fmt.Errorf("the sessions or jobs were not drained in time: %w", drainErr)
+		}
+	}()
+	err = o.serve(c, pkg.NewRemoteServer(server))
+	return
+}
+
+// serve runs the gRPC server of the extension like ext.CreateRunner, except
+// that SIGHUP reloads the config instead of stopping the server
+func (o *option) serve(c *cobra.Command, remoteServer remote.LoaderServer) (err error) {
+	protocol, address := o.GetListenAddress()
+	// remove the exist socket file
+	if o.Socket != "" {
+		_ = os.Remove(o.Socket)
+	}
+
+	var lis net.Listener
+	if lis, err = net.Listen(protocol, address); err != nil {
+		return
+	}
+
+	gRPCServer := grpc.NewServer()
+	remote.RegisterLoaderServer(gRPCServer, remoteServer)
+	reflection.Register(gRPCServer)
+	c.Printf("%s@%s is running at %s\n", o.GetFullName(), version.GetVersion(), address)
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+		select {
+		case <-signals:
+		case <-c.Context().Done():
+		}
+		_ = os.Remove(o.Socket)
+		gRPCServer.Stop()
+	}()
+	err = gRPCServer.Serve(lis)
+	return
+}
+
+// loadConfig reads the config file over the defaults of the flags, then the
+// flags which are given on the command line override it
+func (o *option) loadConfig() (config pkg.Config, err error) {
+	flags := pflag.NewFlagSet("config", pflag.ContinueOnError)
+	flags.ParseErrorsWhitelist.UnknownFlags = true
+	addConfigFlags(flags, &config)
+	if o.configFile != "" {
+		if err = pkg.ReadConfig(o.configFile, &config); err != nil {
+			return
+		}
+	}
+	err = flags.Parse(os.Args[1:])
 	return
 }
 
 type option struct {
 	*ext.Extension
-	serverPort int
+	configFile string
+	config     pkg.Config
 }
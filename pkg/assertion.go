@@ -0,0 +1,268 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/tidwall/gjson"
+)
+
+// Assertion types
+const (
+	AssertExitCode = "exitCode"
+	AssertStdout   = "stdout"
+	AssertStderr   = "stderr"
+	AssertJSON     = "json"
+	AssertLines    = "lines"
+	AssertDuration = "duration"
+	AssertExpr     = "expr"
+)
+
+// Assertion checks the result of a command, the fields depend on the type:
+//
+//	exitCode: equals
+//	stdout, stderr: contains, regex, equals
+//	json: path (gjson) with equals, or exists; the whole output must be JSON without a path
+//	lines: equals, min, max
+//	duration: less
+//	expr: expression over exitCode, stdout, stderr, elapsed (seconds), lines and json
+//
+// The json and lines assertions check the stdout unless the stream is stderr.
+type Assertion struct {
+	Type       string `json:"type" yaml:"type"`
+	Stream     string `json:"stream,omitempty" yaml:"stream,omitempty"`
+	Contains   string `json:"contains,omitempty" yaml:"contains,omitempty"`
+	Regex      string `json:"regex,omitempty" yaml:"regex,omitempty"`
+	Path       string `json:"path,omitempty" yaml:"path,omitempty"`
+	Equals     any    `json:"equals,omitempty" yaml:"equals,omitempty"`
+	Exists     *bool  `json:"exists,omitempty" yaml:"exists,omitempty"`
+	Min        *int   `json:"min,omitempty" yaml:"min,omitempty"`
+	Max        *int   `json:"max,omitempty" yaml:"max,omitempty"`
+	Less       string `json:"less,omitempty" yaml:"less,omitempty"`
+	Expression string `json:"expression,omitempty" yaml:"expression,omitempty"`
+}
+
+// AssertionResult is the result of an assertion
+type AssertionResult struct {
+	Assertion
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+// validate checks the assertion before the command runs
+func (a Assertion) validate() (err error) {
+	switch a.Type {
+	case AssertExitCode:
+		if _, ok := toInt(a.Equals); !ok {
+			err = errors.New("exitCode needs an integer to equal")
+		}
+	case AssertStdout, AssertStderr:
+		if a.Contains == "" && a.Regex == "" && a.Equals == nil {
+			err = fmt.Errorf("%s needs contains, regex or equals", a.Type)
+		} else if a.Regex != "" {
+			_, err = regexp.Compile(a.Regex)
+		}
+	case AssertJSON:
+		if a.Path == "" && (a.Equals != nil || a.Exists != nil) {
+			err = errors.New("json needs a path to check a value")
+		}
+	case AssertLines:
+		if _, ok := toInt(a.Equals); !ok && a.Min == nil && a.Max == nil {
+			err = errors.New("lines needs equals, min or max")
+		}
+	case AssertDuration:
+		if _, err = time.ParseDuration(a.Less); err != nil {
+			err = fmt.Errorf("duration needs a valid duration to be less than: %w", err)
+		}
+	case AssertExpr:
+		_, err = expr.Compile(a.Expression, expr.Env(assertionEnv{}), expr.AsBool())
+	default:
+		err = fmt.Errorf("unknown assertion type: %q", a.Type)
+	}
+	if err == nil && a.Stream != "" && a.Stream != AssertStdout && a.Stream != AssertStderr {
+		err = fmt.Errorf("unknown stream: %q", a.Stream)
+	}
+	return
+}
+
+func validateAssertions(assertions []Assertion) error {
+	for i, assertion := range assertions {
+		if err := assertion.validate(); err != nil {
+			return fmt.Errorf("assertion %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// assert checks the assertions against the response, and records the results in it
+func (r *execResponse) assert(assertions []Assertion) {
+	if len(assertions) == 0 {
+		return
+	}
+	passed := true
+	r.Assertions = make([]AssertionResult, 0, len(assertions))
+	for _, assertion := range assertions {
+		result := AssertionResult{Assertion: assertion, Passed: true}
+		if err := assertion.check(*r); err != nil {
+			result.Passed, result.Message = false, err.Error()
+			passed = false
+		}
+		r.Assertions = append(r.Assertions, result)
+	}
+	r.Passed = &passed
+}
+
+func (a Assertion) stream(resp execResponse) string {
+	if a.Type == AssertStderr || a.Stream == AssertStderr {
+		return resp.Stderr
+	}
+	return resp.Stdout
+}
+
+// check returns an error describing why the assertion fails
+func (a Assertion) check(resp execResponse) error {
+	output := a.stream(resp)
+	switch a.Type {
+	case AssertExitCode:
+		if expected, _ := toInt(a.Equals); resp.ExitCode != expected {
+			return fmt.Errorf("exit code is %d, expected %d", resp.ExitCode, expected)
+		}
+	case AssertStdout, AssertStderr:
+		if a.Contains != "" && !strings.Contains(output, a.Contains) {
+			return fmt.Errorf("%s does not contain %q", a.Type, a.Contains)
+		}
+		if a.Regex != "" && !regexp.MustCompile(a.Regex).MatchString(output) {
+			return fmt.Errorf("%s does not match %q", a.Type, a.Regex)
+		}
+		if a.Equals != nil && strings.TrimRight(output, "\n") != strings.TrimRight(fmt.Sprint(a.Equals), "\n") {
+			return fmt.Errorf("%s is %q, expected %q", a.Type, output, a.Equals)
+		}
+	case AssertJSON:
+		return a.checkJSON(output)
+	case AssertLines:
+		lines := countLines(output)
+		if expected, ok := toInt(a.Equals); ok && lines != expected {
+			return fmt.Errorf("%d lines, expected %d", lines, expected)
+		}
+		if a.Min != nil && lines < *a.Min {
+			return fmt.Errorf("%d lines, expected at least %d", lines, *a.Min)
+		}
+		if a.Max != nil && lines > *a.Max {
+			return fmt.Errorf("%d lines, expected at most %d", lines, *a.Max)
+		}
+	case AssertDuration:
+		if threshold, _ := time.ParseDuration(a.Less); resp.elapsed >= threshold {
+			return fmt.Errorf("took %s, expected less than %s", resp.elapsed, threshold)
+		}
+	case AssertExpr:
+		result, err := expr.Eval(a.Expression, newAssertionEnv(resp))
+		if err != nil {
+			return err
+		}
+		if ok, _ := result.(bool); !ok {
+			return fmt.Errorf("%s is false", a.Expression)
+		}
+	}
+	return nil
+}
+
+func (a Assertion) checkJSON(output string) error {
+	if !gjson.Valid(output) {
+		return errors.New("output is not valid JSON")
+	}
+	if a.Path == "" {
+		return nil
+	}
+
+	result := gjson.Get(output, a.Path)
+	if a.Exists != nil && result.Exists() != *a.Exists {
+		if *a.Exists {
+			return fmt.Errorf("%s does not exist", a.Path)
+		}
+		return fmt.Errorf("%s exists", a.Path)
+	}
+	if a.Equals == nil {
+		if a.Exists == nil && !result.Exists() {
+			return fmt.Errorf("%s does not exist", a.Path)
+		}
+		return nil
+	}
+	if !result.Exists() {
+		return fmt.Errorf("%s does not exist", a.Path)
+	}
+	if !jsonEqual(result.Value(), a.Equals) {
+		return fmt.Errorf("%s is %s, expected %v", a.Path, result.Raw, a.Equals)
+	}
+	return nil
+}
+
+// jsonEqual compares two values by their JSON form, so that 1 equals 1.0
+func jsonEqual(actual, expected any) bool {
+	normalize := func(value any) (normalized any) {
+		data, _ := json.Marshal(value)
+		_ = json.Unmarshal(data, &normalized)
+		return
+	}
+	return reflect.DeepEqual(normalize(actual), normalize(expected))
+}
+
+// assertionEnv is the environment of the expr assertions
+type assertionEnv struct {
+	ExitCode int     `expr:"exitCode"`
+	Stdout   string  `expr:"stdout"`
+	Stderr   string  `expr:"stderr"`
+	Elapsed  float64 `expr:"elapsed"`
+	Lines    int     `expr:"lines"`
+	JSON     any     `expr:"json"`
+}
+
+func newAssertionEnv(resp execResponse) (env assertionEnv) {
+	env = assertionEnv{
+		ExitCode: resp.ExitCode,
+		Stdout:   resp.Stdout,
+		Stderr:   resp.Stderr,
+		Elapsed:  resp.elapsed.Seconds(),
+		Lines:    countLines(resp.Stdout),
+	}
+	_ = json.Unmarshal([]byte(resp.Stdout), &env.JSON)
+	return
+}
+
+func countLines(output string) int {
+	if output == "" {
+		return 0
+	}
+	return strings.Count(strings.TrimSuffix(output, "\n"), "\n") + 1
+}
+
+// toInt accepts the numbers decoded from JSON or YAML
+func toInt(value any) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case float64:
+		return int(v), v == float64(int(v))
+	}
+	return 0, false
+}
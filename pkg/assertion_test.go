@@ -0,0 +1,209 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssertionCheck(t *testing.T) {
+	resp := execResponse{
+		Stdout:   `{"name": "atest", "items": [1, 2], "version": 1.0}` + "\n",
+		Stderr:   "warning: deprecated\nwarning: slow\n",
+		ExitCode: 2,
+		elapsed:  1500 * time.Millisecond,
+	}
+	yes, no := true, false
+	one, two := 1, 2
+
+	tests := []struct {
+		name        string
+		assertion   string
+		expectError string
+	}{{
+		name:      "exit code",
+		assertion: `{"type": "exitCode", "equals": 2}`,
+	}, {
+		name:        "exit code mismatch",
+		assertion:   `{"type": "exitCode", "equals": 0}`,
+		expectError: "exit code is 2, expected 0",
+	}, {
+		name:      "stdout contains",
+		assertion: `{"type": "stdout", "contains": "atest"}`,
+	}, {
+		name:        "stdout does not contain",
+		assertion:   `{"type": "stdout", "contains": "other"}`,
+		expectError: `stdout does not contain "other"`,
+	}, {
+		name:      "stderr regex",
+		assertion: `{"type": "stderr", "regex": "^warning: \\w+"}`,
+	}, {
+		name:      "stderr regex over the lines",
+		assertion: `{"type": "stderr", "regex": "(?m)^warning: slow$"}`,
+	}, {
+		name:        "stderr regex mismatch",
+		assertion:   `{"type": "stderr", "regex": "^error"}`,
+		expectError: `stderr does not match "^error"`,
+	}, {
+		name:      "stderr equals without the trailing new line",
+		assertion: `{"type": "stderr", "equals": "warning: deprecated\nwarning: slow"}`,
+	}, {
+		name:        "stderr equals mismatch",
+		assertion:   `{"type": "stderr", "equals": "warning"}`,
+		expectError: `stderr is "warning: deprecated\nwarning: slow\n", expected "warning"`,
+	}, {
+		name:      "valid JSON",
+		assertion: `{"type": "json"}`,
+	}, {
+		name:        "invalid JSON",
+		assertion:   `{"type": "json", "stream": "stderr"}`,
+		expectError: "output is not valid JSON",
+	}, {
+		name:      "json path equals a string",
+		assertion: `{"type": "json", "path": "name", "equals": "atest"}`,
+	}, {
+		name:      "json path equals a number of another form",
+		assertion: `{"type": "json", "path": "version", "equals": 1}`,
+	}, {
+		name:      "json path equals an array",
+		assertion: `{"type": "json", "path": "items", "equals": [1, 2]}`,
+	}, {
+		name:        "json path mismatch",
+		assertion:   `{"type": "json", "path": "items.#", "equals": 3}`,
+		expectError: "items.# is 2, expected 3",
+	}, {
+		name:        "json path does not exist",
+		assertion:   `{"type": "json", "path": "missing"}`,
+		expectError: "missing does not exist",
+	}, {
+		name:        "json path to equal does not exist",
+		assertion:   `{"type": "json", "path": "missing", "equals": 1}`,
+		expectError: "missing does not exist",
+	}, {
+		name:      "json path does not exist as expected",
+		assertion: `{"type": "json", "path": "missing", "exists": false}`,
+	}, {
+		name:        "json path exists unexpectedly",
+		assertion:   `{"type": "json", "path": "name", "exists": false}`,
+		expectError: "name exists",
+	}, {
+		name:      "lines",
+		assertion: `{"type": "lines", "stream": "stderr", "equals": 2}`,
+	}, {
+		name:        "too few lines",
+		assertion:   `{"type": "lines", "min": 2}`,
+		expectError: "1 lines, expected at least 2",
+	}, {
+		name:        "too many lines",
+		assertion:   `{"type": "lines", "stream": "stderr", "max": 1}`,
+		expectError: "2 lines, expected at most 1",
+	}, {
+		name:      "duration",
+		assertion: `{"type": "duration", "less": "2s"}`,
+	}, {
+		name:        "too slow",
+		assertion:   `{"type": "duration", "less": "1s"}`,
+		expectError: "took 1.5s, expected less than 1s",
+	}, {
+		name:      "expression",
+		assertion: `{"type": "expr", "expression": "exitCode == 2 && json.name == 'atest' && elapsed < 2"}`,
+	}, {
+		name:        "false expression",
+		assertion:   `{"type": "expr", "expression": "lines > 1"}`,
+		expectError: "lines > 1 is false",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var assertion Assertion
+			assert.NoError(t, json.Unmarshal([]byte(tt.assertion), &assertion))
+			assert.NoError(t, assertion.validate())
+			err := assertion.check(resp)
+			if tt.expectError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.expectError)
+			}
+		})
+	}
+
+	t.Run("min and max", func(t *testing.T) {
+		assertion := Assertion{Type: AssertLines, Stream: AssertStderr, Min: &one, Max: &two}
+		assert.NoError(t, assertion.check(resp))
+	})
+	t.Run("exists", func(t *testing.T) {
+		assert.NoError(t, Assertion{Type: AssertJSON, Path: "items.1", Exists: &yes}.check(resp))
+		assert.NoError(t, Assertion{Type: AssertJSON, Path: "items.2", Exists: &no}.check(resp))
+	})
+}
+
+func TestAssertionValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		assertion   string
+		expectError string
+	}{{
+		name:        "unknown type",
+		assertion:   `{"type": "status"}`,
+		expectError: `unknown assertion type: "status"`,
+	}, {
+		name:        "exit code without a value",
+		assertion:   `{"type": "exitCode"}`,
+		expectError: "exitCode needs an integer to equal",
+	}, {
+		name:        "exit code which is not an integer",
+		assertion:   `{"type": "exitCode", "equals": 1.5}`,
+		expectError: "exitCode needs an integer to equal",
+	}, {
+		name:        "stdout without a matcher",
+		assertion:   `{"type": "stdout"}`,
+		expectError: "stdout needs contains, regex or equals",
+	}, {
+		name:        "invalid regex",
+		assertion:   `{"type": "stderr", "regex": "("}`,
+		expectError: "error parsing regexp: missing closing ): `(`",
+	}, {
+		name:        "json value without a path",
+		assertion:   `{"type": "json", "equals": 1}`,
+		expectError: "json needs a path to check a value",
+	}, {
+		name:        "lines without a limit",
+		assertion:   `{"type": "lines"}`,
+		expectError: "lines needs equals, min or max",
+	}, {
+		name:        "invalid duration",
+		assertion:   `{"type": "duration", "less": "soon"}`,
+		expectError: `duration needs a valid duration to be less than: time: invalid duration "soon"`,
+	}, {
+		name:        "expression which is not a boolean",
+		assertion:   `{"type": "expr", "expression": "exitCode + 1"}`,
+		expectError: "expected bool, but got int",
+	}, {
+		name:        "unknown stream",
+		assertion:   `{"type": "lines", "stream": "stdin", "min": 1}`,
+		expectError: `unknown stream: "stdin"`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var assertion Assertion
+			assert.NoError(t, json.Unmarshal([]byte(tt.assertion), &assertion))
+			assert.EqualError(t, assertion.validate(), tt.expectError)
+		})
+	}
+}
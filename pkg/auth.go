@@ -0,0 +1,95 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AuthConfig requires the clients to send one of the tokens, which decides
+// the user of the request. All the requests are allowed if it has no tokens.
+type AuthConfig struct {
+	Tokens []AuthToken `json:"tokens,omitempty" yaml:"tokens,omitempty"`
+}
+
+// AuthToken is the token of a user
+type AuthToken struct {
+	User  string `json:"user" yaml:"user"`
+	Token string `json:"-" yaml:"token"`
+}
+
+// userKey is the context key of the authenticated user
+type userKey struct{}
+
+func (c AuthConfig) validate() error {
+	tokens := map[string]bool{}
+	for i, item := range c.Tokens {
+		switch {
+		case item.User == "":
+			return fmt.Errorf("tokens[%d]: user is required", i)
+		case item.Token == "":
+			return fmt.Errorf("tokens[%d]: token is required", i)
+		case tokens[item.Token]:
+			return fmt.Errorf("tokens[%d]: duplicated token", i)
+		}
+		tokens[item.Token] = true
+	}
+	return nil
+}
+
+// authenticate returns the user of the token
+func (c AuthConfig) authenticate(token string) (user string, err error) {
+	if token == "" {
+		return "", errors.New("the token is required")
+	}
+	for _, item := range c.Tokens {
+		if subtle.ConstantTimeCompare([]byte(item.Token), []byte(token)) == 1 {
+			return item.User, nil
+		}
+	}
+	return "", errors.New("invalid token")
+}
+
+// withAuth checks the token of the requests when the tokens of the server
+// are configured. The token is sent in the Authorization header, or the token
+// query parameter since the browsers can't set headers on WebSocket connections.
+func (s *ExecServer) withAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config := s.config.get().Auth
+		if len(config.Tokens) == 0 || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("token")
+		}
+		user, err := config.authenticate(strings.TrimSpace(token))
+		if err != nil {
+			s.logger.Warn("unauthorized request", "path", r.URL.Path, "remote", r.RemoteAddr, "error", err)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	})
+}
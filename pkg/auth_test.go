@@ -0,0 +1,102 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestUser(t *testing.T) {
+	tests := []struct {
+		name         string
+		tokens       []AuthToken
+		token        string
+		expectStatus int
+		expectUser   string
+	}{{
+		name:         "without tokens",
+		expectStatus: http.StatusOK,
+		expectUser:   "192.0.2.1",
+	}, {
+		name:         "user of the token",
+		tokens:       []AuthToken{{User: "alice", Token: "alice-token"}},
+		token:        "alice-token",
+		expectStatus: http.StatusOK,
+		expectUser:   "alice",
+	}, {
+		name:         "invalid token",
+		tokens:       []AuthToken{{User: "alice", Token: "alice-token"}},
+		token:        "bob-token",
+		expectStatus: http.StatusUnauthorized,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &ExecServer{logger: slog.Default()}
+			server.config.set(Config{Auth: AuthConfig{Tokens: tt.tokens}})
+
+			var user string
+			handler := server.withAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				user = requestUser(r)
+			}))
+			request := httptest.NewRequest(http.MethodGet, "/api/sessions", nil)
+			request.RemoteAddr = "192.0.2.1:4321"
+			// the header of the client never decides the user
+			request.Header.Set("X-User", "mallory")
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tt.expectStatus, recorder.Code)
+			assert.Equal(t, tt.expectUser, user)
+		})
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	tokens := []AuthToken{{User: "alice", Token: "alice-token"}}
+	server := newTestServer(t, &FakeRunner{}, func(config *Config) {
+		config.Auth.Tokens = tokens
+	})
+	other := newTestServer(t, &FakeRunner{})
+
+	request := func(server *ExecServer, token string) int {
+		request := httptest.NewRequest(http.MethodGet, "/api/sessions", nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		server.handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, request(server, ""))
+	assert.Equal(t, http.StatusOK, request(server, "alice-token"))
+	// the tokens of a server don't apply to the others
+	assert.Equal(t, http.StatusOK, request(other, ""))
+
+	config := other.config.get()
+	config.Auth.Tokens = tokens
+	require.NoError(t, other.ReloadConfig(config))
+	assert.Equal(t, http.StatusUnauthorized, request(other, ""))
+	assert.Equal(t, http.StatusOK, request(server, "alice-token"))
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"github.com/creack/pty"
+)
+
+// Backend starts the shells of the terminal sessions, either on this host or
+// on a remote one
+type Backend interface {
+	// Start starts the shell on a terminal of the size
+	Start(ctx context.Context, cols, rows int) (PTY, error)
+}
+
+// PTY is the PTY-like stream of a running shell
+type PTY interface {
+	// Read reads the output of the terminal, Write writes its input, and
+	// Close terminates the shell
+	io.ReadWriteCloser
+	// Resize changes the size of the terminal
+	Resize(cols, rows int) error
+	// Signal sends a signal by its name without the SIG prefix, for instance: INT
+	Signal(name string) error
+	// Wait waits for the shell to exit, it can be called more than once
+	Wait() error
+	// Pid is the ID of the local process, it's 0 for the remote shells
+	Pid() int
+}
+
+// shellSignals are the signals which can be sent to the shells
+var shellSignals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
+
+// newBackend returns the backend of the profile, the local one prepares the
+// command with the environment policy and the hook before it starts
+func newBackend(runner CommandRunner, profile Profile, p *policies, seccompProfile string, prepare func(*exec.Cmd)) (Backend, error) {
+	if profile.SSH != nil {
+		if seccompProfile != "" {
+			return nil, fmt.Errorf("profile %q connects to a remote host, seccomp is not supported", profile.Name)
+		}
+		return &sshBackend{config: *profile.SSH, profile: profile}, nil
+	}
+	return &localBackend{
+		runner:          runner,
+		profile:         profile,
+		env:             p.env,
+		seccompProfiles: p.seccomp,
+		seccomp:         seccompProfile,
+		prepare:         prepare,
+	}, nil
+}
+
+// localBackend starts the shell of the profile on a local PTY
+type localBackend struct {
+	runner  CommandRunner
+	profile Profile
+	env     EnvPolicy
+	// seccomp is the name of one of the seccomp profiles
+	seccomp         string
+	seccompProfiles map[string]SeccompProfile
+	prepare         func(*exec.Cmd)
+}
+
+func (b *localBackend) Start(_ context.Context, cols, rows int) (PTY, error) {
+	cmd, err := b.profile.shellCommand(b.env)
+	if err != nil {
+		return nil, err
+	}
+	if err = applyIsolation(cmd, b.profile.Isolation); err != nil {
+		return nil, err
+	}
+	if err = applySeccomp(cmd, b.seccompProfiles, b.seccomp); err != nil {
+		return nil, err
+	}
+	if b.prepare != nil {
+		b.prepare(cmd)
+	}
+
+	ptmx, err := b.runner.StartPTY(cmd)
+	if err != nil {
+		return nil, wrapIsolationError(b.profile.Isolation, err)
+	}
+	shell := &localShell{cmd: cmd, pty: ptmx}
+	_ = shell.Resize(cols, rows)
+	return shell, nil
+}
+
+// localShell is a local process attached to a PTY, it leads its own process group
+type localShell struct {
+	cmd     *exec.Cmd
+	pty     *os.File
+	waitErr error
+	once    sync.Once
+}
+
+func (s *localShell) Read(data []byte) (int, error) {
+	return s.pty.Read(data)
+}
+
+func (s *localShell) Write(data []byte) (int, error) {
+	return s.pty.Write(data)
+}
+
+// Close closes the PTY, then kills the process group and waits for it
+func (s *localShell) Close() error {
+	err := s.pty.Close()
+	_ = killProcessGroup(s.cmd)
+	_ = s.Wait()
+	return err
+}
+
+func (s *localShell) Resize(cols, rows int) error {
+	return pty.Setsize(s.pty, &pty.Winsize{Cols: uint16(cols), Rows: uint16(rows)})
+}
+
+func (s *localShell) Signal(name string) error {
+	sig, ok := shellSignals[name]
+	if !ok {
+		return fmt.Errorf("unsupported signal %q", name)
+	}
+	return signalForeground(s.pty, s.cmd, sig)
+}
+
+func (s *localShell) Wait() error {
+	s.once.Do(func() {
+		s.waitErr = s.cmd.Wait()
+	})
+	return s.waitErr
+}
+
+func (s *localShell) Pid() int {
+	return s.cmd.Process.Pid
+}
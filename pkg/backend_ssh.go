@@ -0,0 +1,454 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// the timeout of connecting and authenticating to an SSH server
+const sshDialTimeout = 10 * time.Second
+
+// SSHConfig opens the sessions of a profile on a remote host instead of a
+// local shell, the command, the args, the dir and the env of the profile
+// apply on the remote host
+type SSHConfig struct {
+	Host string `json:"host" yaml:"host"`
+	// Port is 22 if it's zero
+	Port int    `json:"port,omitempty" yaml:"port,omitempty"`
+	User string `json:"user" yaml:"user"`
+	// Password is never sent to the clients
+	Password string `json:"-" yaml:"password,omitempty"`
+	// IdentityFile is the private key, a leading ~ is the home directory
+	IdentityFile string `json:"identityFile,omitempty" yaml:"identityFile,omitempty"`
+	Passphrase   string `json:"-" yaml:"passphrase,omitempty"`
+	// Agent authenticates with the keys of the agent listening on $SSH_AUTH_SOCK
+	Agent bool `json:"agent,omitempty" yaml:"agent,omitempty"`
+	// KnownHostsFile verifies the host key, it's ~/.ssh/known_hosts by default
+	KnownHostsFile string `json:"knownHostsFile,omitempty" yaml:"knownHostsFile,omitempty"`
+
+	// privateKey is the key of a stored host, it's used instead of IdentityFile
+	privateKey []byte
+	// knownHosts verifies the key of a stored host instead of KnownHostsFile
+	knownHosts *knownHostsStore
+	// jump is the host which the connections go through
+	jump *SSHConfig
+}
+
+func (c SSHConfig) validate() error {
+	if c.Host == "" || c.User == "" {
+		return errors.New("ssh: host and user are required")
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("ssh: invalid port %d", c.Port)
+	}
+	if c.Password == "" && c.IdentityFile == "" && !c.Agent {
+		return errors.New("ssh: one of password, identityFile and agent is required")
+	}
+	return nil
+}
+
+// verify checks the files and the agent which the connections need
+func (c SSHConfig) verify() (err error) {
+	var file string
+	if file, err = c.knownHostsFile(); err == nil {
+		_, err = os.Stat(file)
+	}
+	if err == nil && c.IdentityFile != "" {
+		if file, err = expandHome(c.IdentityFile); err == nil {
+			_, err = os.Stat(file)
+		}
+	}
+	if err == nil && c.Agent && os.Getenv("SSH_AUTH_SOCK") == "" {
+		err = errors.New("SSH_AUTH_SOCK is not set")
+	}
+	return
+}
+
+func (c SSHConfig) address() string {
+	port := c.Port
+	if port == 0 {
+		port = 22
+	}
+	return net.JoinHostPort(c.Host, strconv.Itoa(port))
+}
+
+func (c SSHConfig) knownHostsFile() (string, error) {
+	if c.KnownHostsFile == "" {
+		return expandHome(filepath.Join("~", ".ssh", "known_hosts"))
+	}
+	return expandHome(c.KnownHostsFile)
+}
+
+// authMethods returns the agent, the private key and the password in order,
+// the agent connection is closed by the caller once authenticated
+func (c SSHConfig) authMethods() (methods []ssh.AuthMethod, agentConn io.Closer, err error) {
+	if c.Agent {
+		var conn net.Conn
+		if conn, err = net.Dial("unix", os.Getenv("SSH_AUTH_SOCK")); err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to the SSH agent: %w", err)
+		}
+		agentConn = conn
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+	if c.IdentityFile != "" || len(c.privateKey) > 0 {
+		var signer ssh.Signer
+		if signer, err = c.identity(); err != nil {
+			if agentConn != nil {
+				_ = agentConn.Close()
+			}
+			return nil, nil, err
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if c.Password != "" {
+		password := c.Password
+		methods = append(methods, ssh.Password(password),
+			ssh.KeyboardInteractive(func(_, _ string, questions []string, _ []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = password
+				}
+				return answers, nil
+			}))
+	}
+	return
+}
+
+func (c SSHConfig) identity() (signer ssh.Signer, err error) {
+	if len(c.privateKey) > 0 {
+		if signer, err = parsePrivateKey(c.privateKey, c.Passphrase); err != nil {
+			err = fmt.Errorf("invalid private key: %w", err)
+		}
+		return
+	}
+
+	var file string
+	var data []byte
+	if file, err = expandHome(c.IdentityFile); err == nil {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the identity file: %w", err)
+	}
+	if signer, err = parsePrivateKey(data, c.Passphrase); err != nil {
+		err = fmt.Errorf("invalid identity file %s: %w", file, err)
+	}
+	return
+}
+
+func parsePrivateKey(data []byte, passphrase string) (ssh.Signer, error) {
+	if passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	}
+	return ssh.ParsePrivateKey(data)
+}
+
+// sshBackend starts the shell of the profile on a remote host
+type sshBackend struct {
+	config  SSHConfig
+	profile Profile
+}
+
+func (b *sshBackend) Start(ctx context.Context, cols, rows int) (shell PTY, err error) {
+	client, err := b.config.dial(ctx)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = client.Close()
+		}
+	}()
+
+	var session *ssh.Session
+	if session, err = client.NewSession(); err != nil {
+		return
+	}
+	remote := &sshShell{client: client, session: session}
+	for key, value := range b.profile.Env {
+		// the servers accept the variables of AcceptEnv only
+		_ = session.Setenv(key, value)
+	}
+	modes := ssh.TerminalModes{ssh.ECHO: 1, ssh.TTY_OP_ISPEED: 14400, ssh.TTY_OP_OSPEED: 14400}
+	if err = session.RequestPty("xterm-256color", rows, cols, modes); err != nil {
+		return
+	}
+	if remote.stdin, err = session.StdinPipe(); err != nil {
+		return
+	}
+	if remote.stdout, err = session.StdoutPipe(); err != nil {
+		return
+	}
+
+	if command := b.command(); command == "" {
+		err = session.Shell()
+	} else {
+		err = session.Start(command)
+	}
+	if err == nil {
+		shell = remote
+	}
+	return
+}
+
+// dial connects and authenticates, the host key is verified against the known hosts
+func (c SSHConfig) dial(ctx context.Context) (client *ssh.Client, err error) {
+	methods, agentConn, err := c.authMethods()
+	if err != nil {
+		return
+	}
+	if agentConn != nil {
+		defer agentConn.Close()
+	}
+
+	address := c.address()
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return
+	}
+	_ = conn.SetDeadline(time.Now().Add(sshDialTimeout))
+
+	// the user may take a while to answer the prompt of an unknown host key
+	prompt := trustPromptFrom(ctx)
+	if prompt != nil {
+		ask := prompt
+		prompt = func(host string, key ssh.PublicKey) bool {
+			_ = conn.SetDeadline(time.Time{})
+			defer conn.SetDeadline(time.Now().Add(sshDialTimeout))
+			return ask(host, key)
+		}
+	}
+	known, verify, err := c.hostKeyCallbacks(prompt)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	config := &ssh.ClientConfig{
+		User:              c.User,
+		Auth:              methods,
+		HostKeyCallback:   verify,
+		HostKeyAlgorithms: knownHostKeyAlgorithms(known, address, conn.RemoteAddr()),
+		Timeout:           sshDialTimeout,
+	}
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	client = ssh.NewClient(sshConn, channels, requests)
+	return
+}
+
+// connect opens the connection to the host, it's tunneled through the jump
+// host if there is one
+func (c SSHConfig) connect(ctx context.Context) (conn net.Conn, err error) {
+	if c.jump == nil {
+		dialer := net.Dialer{Timeout: sshDialTimeout}
+		return dialer.DialContext(ctx, "tcp", c.address())
+	}
+
+	var jump *ssh.Client
+	if jump, err = c.jump.dial(ctx); err != nil {
+		return nil, fmt.Errorf("jump host %s: %w", c.jump.address(), err)
+	}
+	if conn, err = jump.DialContext(ctx, "tcp", c.address()); err != nil {
+		_ = jump.Close()
+		return nil, fmt.Errorf("failed to connect to %s through %s: %w", c.address(), c.jump.address(), err)
+	}
+	return &jumpConn{Conn: conn, jump: jump}, nil
+}
+
+// hostKeyCallbacks returns the callback which looks up the known keys, and
+// the one which verifies the key of the server
+func (c SSHConfig) hostKeyCallbacks(prompt trustPrompt) (known, verify ssh.HostKeyCallback, err error) {
+	if c.knownHosts != nil {
+		if known, err = c.knownHosts.callback(); err == nil {
+			verify = c.knownHosts.verify(known, prompt)
+		}
+	} else {
+		var file string
+		if file, err = c.knownHostsFile(); err == nil {
+			known, err = knownhosts.New(file)
+			verify = known
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to load the known hosts: %w", err)
+	}
+	return
+}
+
+// scanHostKey returns the key which the host offers, neither the key nor the
+// user is verified
+func (c SSHConfig) scanHostKey(ctx context.Context) (key ssh.PublicKey, err error) {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(sshDialTimeout))
+
+	errScanned := errors.New("the host key is scanned")
+	config := &ssh.ClientConfig{
+		User: c.User,
+		HostKeyCallback: func(_ string, _ net.Addr, hostKey ssh.PublicKey) error {
+			key = hostKey
+			return errScanned
+		},
+	}
+	if _, _, _, err = ssh.NewClientConn(conn, c.address(), config); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("failed to get the host key of %s: %w", c.address(), err)
+}
+
+// jumpConn is a connection tunneled through a jump host, the connection to
+// the jump host is closed with it
+type jumpConn struct {
+	net.Conn
+	jump *ssh.Client
+}
+
+func (c *jumpConn) Close() error {
+	return errors.Join(c.Conn.Close(), c.jump.Close())
+}
+
+// command is the remote command of the profile, the login shell of the user
+// starts if it's empty
+func (b *sshBackend) command() string {
+	command := ""
+	if b.profile.Command != "" {
+		words := []string{shellQuote(b.profile.Command)}
+		for _, arg := range b.profile.Args {
+			words = append(words, shellQuote(arg))
+		}
+		command = "exec " + strings.Join(words, " ")
+	}
+	if b.profile.Dir == "" {
+		return command
+	}
+
+	dir := shellQuote(b.profile.Dir)
+	if rest, ok := strings.CutPrefix(b.profile.Dir, "~/"); ok {
+		dir = "~/" + shellQuote(rest)
+	} else if b.profile.Dir == "~" {
+		dir = "~"
+	}
+	if command == "" {
+		command = `exec "${SHELL:-sh}" -l`
+	}
+	return "cd " + dir + " && " + command
+}
+
+// knownHostKeyAlgorithms returns the algorithms of the known keys of the
+// host, so that the server offers a key which can be verified
+func knownHostKeyAlgorithms(callback ssh.HostKeyCallback, address string, remote net.Addr) (algorithms []string) {
+	for _, known := range knownKeys(callback, address, remote) {
+		if known.Key.Type() == ssh.KeyAlgoRSA {
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algorithms = append(algorithms, known.Key.Type())
+	}
+	return
+}
+
+// knownKeys returns the known keys of the host
+func knownKeys(callback ssh.HostKeyCallback, address string, remote net.Addr) []knownhosts.KnownKey {
+	// a new key is never known, the error tells the known ones
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(callback(address, remote, key), &keyErr) {
+		return nil
+	}
+	return keyErr.Want
+}
+
+// shellQuote quotes a word for the POSIX shells
+func shellQuote(word string) string {
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// sshShell is a shell on a PTY of a remote host
+type sshShell struct {
+	client  *ssh.Client
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  io.Reader
+	waitErr error
+	once    sync.Once
+}
+
+func (s *sshShell) Read(data []byte) (int, error) {
+	return s.stdout.Read(data)
+}
+
+func (s *sshShell) Write(data []byte) (int, error) {
+	return s.stdin.Write(data)
+}
+
+// Close closes the session and the connection, the remote shell gets SIGHUP
+func (s *sshShell) Close() error {
+	_ = s.session.Close()
+	return s.client.Close()
+}
+
+func (s *sshShell) Resize(cols, rows int) error {
+	return s.session.WindowChange(rows, cols)
+}
+
+func (s *sshShell) Signal(name string) error {
+	if _, ok := shellSignals[name]; !ok {
+		return fmt.Errorf("unsupported signal %q", name)
+	}
+	return s.session.Signal(ssh.Signal(name))
+}
+
+func (s *sshShell) Wait() error {
+	s.once.Do(func() {
+		s.waitErr = s.session.Wait()
+	})
+	return s.waitErr
+}
+
+func (s *sshShell) Pid() int {
+	return 0
+}
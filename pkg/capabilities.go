@@ -0,0 +1,173 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/linuxsuren/api-testing/pkg/version"
+)
+
+// supportedProtocols are the versions of the protocols a client can speak
+var supportedProtocols = []string{
+	// raw terminal bytes over /extensionProxy/terminal/ws
+	"terminal.ws.v1",
+	// server-sent events over /extensionProxy/terminal/exec
+	"terminal.sse.v1",
+	// recordings replayed over /extensionProxy/terminal/replay
+	"terminal.replay.v1",
+}
+
+// knownShells are looked up in PATH in addition to the ones of /etc/shells
+var knownShells = []string{"sh", "bash", "zsh", "fish", "dash", "ksh", "tcsh", "nu", "pwsh", "powershell", "cmd"}
+
+// Capabilities tells the clients what the server supports, so that they do
+// not need to guess it from the OS
+type Capabilities struct {
+	Version      string          `json:"version"`
+	OS           string          `json:"os"`
+	Arch         string          `json:"arch"`
+	PTY          bool            `json:"pty"`
+	DefaultShell string          `json:"defaultShell"`
+	Shells       []Shell         `json:"shells"`
+	Protocols    []string        `json:"protocols"`
+	Features     map[string]bool `json:"features"`
+	Limits       Limits          `json:"limits"`
+	Profiles     []string        `json:"profiles"`
+}
+
+// Shell is an available shell of the server
+type Shell struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// Limits are the configured limits of the commands and sessions
+type Limits struct {
+	ExecTimeout string       `json:"execTimeout"`
+	Session     CgroupLimits `json:"session"`
+	Concurrency LimitConfig  `json:"concurrency"`
+}
+
+func (s *ExecServer) capabilities() Capabilities {
+	supportPTY := ptySupported()
+	p := s.policies.get()
+	cgroup := s.config.get().Cgroup
+	profileNames := []string{}
+	for _, profile := range p.profiles {
+		profileNames = append(profileNames, profile.Name)
+	}
+
+	return Capabilities{
+		Version:      version.GetVersion(),
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		PTY:          supportPTY,
+		DefaultShell: defaultShell(),
+		Shells:       discoverShells(),
+		Protocols:    supportedProtocols,
+		Features:     enabledFeatures(supportPTY, p.recording.Dir != "", cgroup.Root != ""),
+		Limits: Limits{
+			ExecTimeout: execTimeout.String(),
+			Session:     cgroup.Limits,
+			Concurrency: p.limits,
+		},
+		Profiles: profileNames,
+	}
+}
+
+// enabledFeatures reports the optional features which work on this server
+func enabledFeatures(supportPTY, recording, cgroup bool) map[string]bool {
+	return map[string]bool{
+		"profiles":     true,
+		"isolation":    runtime.GOOS == "linux",
+		"seccomp":      seccompSupported(),
+		"cgroup":       cgroup,
+		"recording":    recording,
+		"playback":     recording,
+		"resize":       supportPTY,
+		"snapshot":     true,
+		"expect":       supportPTY,
+		"jobs":         true,
+		"schedules":    true,
+		"metrics":      true,
+		"ssh":          true,
+		"hosts":        true,
+		"signals":      supportPTY,
+		"fileTransfer": false,
+	}
+}
+
+func ptySupported() bool {
+	return checkPTY() == nil
+}
+
+// discoverShells parses /etc/shells, then looks for the well-known shells in PATH
+func discoverShells() (shells []Shell) {
+	seen := map[string]bool{}
+	add := func(path string) {
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			path = resolved
+		}
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+		shells = append(shells, Shell{
+			Name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+			Path: path,
+		})
+	}
+
+	if f, err := os.Open("/etc/shells"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if info, err := os.Stat(line); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+				add(line)
+			}
+		}
+	}
+
+	for _, name := range knownShells {
+		if path, err := exec.LookPath(name); err == nil {
+			add(path)
+		}
+	}
+	return
+}
+
+func (s *ExecServer) handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(s.capabilities())
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// CgroupConfig describes the delegated cgroup v2 subtree in which every
+// terminal session gets its own sub-group
+type CgroupConfig struct {
+	// Root is the path of the delegated cgroup, it's disabled if empty
+	Root   string       `json:"root" yaml:"root"`
+	Limits CgroupLimits `json:"limits" yaml:"limits"`
+}
+
+// CgroupLimits are written to the interface files of each session cgroup,
+// the values use the kernel format, for instance "512M", "50000 100000" or "max"
+type CgroupLimits struct {
+	MemoryMax string `json:"memoryMax,omitempty" yaml:"memoryMax,omitempty"`
+	CPUMax    string `json:"cpuMax,omitempty" yaml:"cpuMax,omitempty"`
+	PidsMax   string `json:"pidsMax,omitempty" yaml:"pidsMax,omitempty"`
+}
+
+// the formats of the limits, which the kernel would refuse otherwise
+var (
+	cgroupMemoryMax = regexp.MustCompile(`^(max|\d+[KMGTkmgt]?)$`)
+	cgroupCPUMax    = regexp.MustCompile(`^(max|[1-9]\d*)( [1-9]\d*)?$`)
+	cgroupPidsMax   = regexp.MustCompile(`^(max|\d+)$`)
+)
+
+// validate checks the limits before they're written to a session cgroup
+func (l CgroupLimits) validate() error {
+	for _, limit := range []struct {
+		name, value, expected string
+		format                *regexp.Regexp
+	}{
+		{"memoryMax", l.MemoryMax, "max or bytes, for instance 512M", cgroupMemoryMax},
+		{"cpuMax", l.CPUMax, "max or the quota, with the period, in microseconds, for instance 50000 100000", cgroupCPUMax},
+		{"pidsMax", l.PidsMax, "max or a number", cgroupPidsMax},
+	} {
+		if limit.value != "" && !limit.format.MatchString(limit.value) {
+			return fmt.Errorf("%s: invalid value %q, expected %s", limit.name, limit.value, limit.expected)
+		}
+	}
+	return nil
+}
+
+// CgroupStats is the live resource usage of a session cgroup
+type CgroupStats struct {
+	Path          string `json:"path"`
+	MemoryCurrent int64  `json:"memoryCurrent"`
+	MemoryPeak    int64  `json:"memoryPeak,omitempty"`
+	MemoryMax     string `json:"memoryMax"`
+	CPUUsageUsec  int64  `json:"cpuUsageUsec"`
+	CPUMax        string `json:"cpuMax"`
+	PidsCurrent   int64  `json:"pidsCurrent"`
+	PidsMax       string `json:"pidsMax"`
+}
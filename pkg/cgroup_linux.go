@@ -0,0 +1,229 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// cgroup is the cgroup v2 sub-group of a single session
+type cgroup struct {
+	path string
+	dir  *os.File
+}
+
+// enableCgroupControllers makes sure the root is a cgroup v2 directory and
+// enables the controllers needed by the limits for its children
+func enableCgroupControllers(root string) (err error) {
+	var fs unix.Statfs_t
+	if err = unix.Statfs(root, &fs); err != nil {
+		return fmt.Errorf("invalid cgroup root %q: %w", root, err)
+	}
+	if fs.Type != unix.CGROUP2_SUPER_MAGIC {
+		return fmt.Errorf("invalid cgroup root %q: not a cgroup v2 filesystem", root)
+	}
+
+	var data []byte
+	if data, err = os.ReadFile(filepath.Join(root, "cgroup.controllers")); err != nil {
+		return
+	}
+	available := strings.Fields(string(data))
+	for _, controller := range []string{"cpu", "memory", "pids"} {
+		if !slices.Contains(available, controller) {
+			return fmt.Errorf("the %s controller is not delegated to the cgroup %q", controller, root)
+		}
+	}
+	// a cgroup which has processes can't enable the controllers for its
+	// children, this is the case when the server is started in the root
+	if err = moveCgroupProcesses(root, filepath.Join(root, cgroupServerLeaf)); err != nil {
+		return
+	}
+	if err = os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte("+cpu +memory +pids"), 0644); err != nil {
+		if errors.Is(err, syscall.EBUSY) {
+			err = fmt.Errorf("failed to enable controllers of the cgroup %q, it still has processes of its own: %w", root, err)
+		} else {
+			err = fmt.Errorf("failed to enable controllers of the cgroup %q: %w", root, err)
+		}
+	}
+	return
+}
+
+// cgroupServerLeaf is the sub-group which the processes found in the root
+// are moved into, the sessions get their own sub-groups next to it
+const cgroupServerLeaf = "server"
+
+// moveCgroupProcesses moves every process of the cgroup into the leaf
+func moveCgroupProcesses(root, leaf string) (err error) {
+	var data []byte
+	if data, err = os.ReadFile(filepath.Join(root, "cgroup.procs")); err != nil {
+		return
+	}
+	pids := strings.Fields(string(data))
+	if len(pids) == 0 {
+		return
+	}
+	if err = os.Mkdir(leaf, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("failed to create the cgroup %q: %w", leaf, err)
+	}
+	for _, pid := range pids {
+		// the process may have exited meanwhile
+		if err = os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(pid), 0644); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("failed to move the process %s into the cgroup %q: %w", pid, leaf, err)
+		}
+	}
+	return nil
+}
+
+// newCgroup creates the sub-group of a session and applies the limits
+func newCgroup(root, name string, limits CgroupLimits) (group *cgroup, err error) {
+	path := filepath.Join(root, name)
+	if err = os.Mkdir(path, 0755); err != nil {
+		return
+	}
+
+	group = &cgroup{path: path}
+	defer func() {
+		if err != nil {
+			_ = group.close()
+			group = nil
+		}
+	}()
+
+	for file, value := range map[string]string{
+		"memory.max": limits.MemoryMax,
+		"cpu.max":    limits.CPUMax,
+		"pids.max":   limits.PidsMax,
+	} {
+		if value == "" {
+			continue
+		}
+		if err = os.WriteFile(filepath.Join(path, file), []byte(value), 0644); err != nil {
+			err = fmt.Errorf("failed to set %s to %q: %w", file, value, err)
+			return
+		}
+	}
+
+	group.dir, err = os.Open(path)
+	return
+}
+
+// attach makes the command start directly inside the cgroup
+func (c *cgroup) attach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(c.dir.Fd())
+}
+
+func (c *cgroup) stats() (stats CgroupStats, err error) {
+	stats.Path = c.path
+	if stats.MemoryCurrent, err = c.readInt("memory.current"); err != nil {
+		return
+	}
+	// memory.peak only exists since Linux 5.19
+	stats.MemoryPeak, _ = c.readInt("memory.peak")
+	if stats.PidsCurrent, err = c.readInt("pids.current"); err != nil {
+		return
+	}
+	if stats.CPUUsageUsec, err = c.readKey("cpu.stat", "usage_usec"); err != nil {
+		return
+	}
+	stats.MemoryMax, _ = c.read("memory.max")
+	stats.CPUMax, _ = c.read("cpu.max")
+	stats.PidsMax, _ = c.read("pids.max")
+	return
+}
+
+// close kills every process in the cgroup, then removes it
+func (c *cgroup) close() (err error) {
+	if c.dir != nil {
+		_ = c.dir.Close()
+	}
+
+	// cgroup.kill only exists since Linux 5.14
+	if os.WriteFile(filepath.Join(c.path, "cgroup.kill"), []byte("1"), 0644) != nil {
+		c.killProcesses()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err = os.Remove(c.path); err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("failed to remove cgroup %q: %w", c.path, err)
+		}
+		c.killProcesses()
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (c *cgroup) killProcesses() {
+	data, err := os.ReadFile(filepath.Join(c.path, "cgroup.procs"))
+	if err != nil {
+		return
+	}
+	for _, field := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+}
+
+func (c *cgroup) read(file string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(c.path, file))
+	return strings.TrimSpace(string(data)), err
+}
+
+func (c *cgroup) readInt(file string) (int64, error) {
+	value, err := c.read(file)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// readKey reads a value from a flat keyed file, for instance cpu.stat
+func (c *cgroup) readKey(file, key string) (value int64, err error) {
+	var f *os.File
+	if f, err = os.Open(filepath.Join(c.path, file)); err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			return strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+	err = fmt.Errorf("%s not found in %s", key, file)
+	return
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestMoveCgroupProcesses(t *testing.T) {
+	root := cgroupTestRoot(t)
+	leaf := filepath.Join(root, cgroupServerLeaf)
+
+	cmd := exec.Command("sleep", "30")
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		_ = os.Remove(leaf)
+		_ = os.Remove(root)
+	})
+	pid := strconv.Itoa(cmd.Process.Pid)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "cgroup.procs"), []byte(pid), 0644))
+
+	require.NoError(t, moveCgroupProcesses(root, leaf))
+	assert.Equal(t, "", readCgroupFile(t, root, "cgroup.procs"))
+	assert.Equal(t, pid, readCgroupFile(t, leaf, "cgroup.procs"))
+
+	// nothing is left to move
+	require.NoError(t, moveCgroupProcesses(root, leaf))
+}
+
+// cgroupTestRoot creates a cgroup v2 directory, the test is skipped if
+// there's no writable cgroup v2 hierarchy
+func cgroupTestRoot(t *testing.T) string {
+	for _, mount := range []string{"/sys/fs/cgroup", "/sys/fs/cgroup/unified"} {
+		var fs unix.Statfs_t
+		if unix.Statfs(mount, &fs) != nil || fs.Type != unix.CGROUP2_SUPER_MAGIC {
+			continue
+		}
+		root := filepath.Join(mount, "atest-test-"+strconv.Itoa(os.Getpid()))
+		if os.Mkdir(root, 0755) == nil {
+			return root
+		}
+	}
+	t.Skip("no writable cgroup v2 hierarchy")
+	return ""
+}
+
+func readCgroupFile(t *testing.T, dir, file string) string {
+	data, err := os.ReadFile(filepath.Join(dir, file))
+	require.NoError(t, err)
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,46 @@
+//go:build !linux
+
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errCgroupUnsupported = errors.New("cgroup v2 is only supported on Linux")
+
+type cgroup struct{}
+
+func enableCgroupControllers(root string) error {
+	return errCgroupUnsupported
+}
+
+func newCgroup(root, name string, limits CgroupLimits) (*cgroup, error) {
+	return nil, errCgroupUnsupported
+}
+
+func (c *cgroup) attach(cmd *exec.Cmd) {}
+
+func (c *cgroup) stats() (CgroupStats, error) {
+	return CgroupStats{}, errCgroupUnsupported
+}
+
+func (c *cgroup) close() error {
+	return nil
+}
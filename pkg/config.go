@@ -0,0 +1,272 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// how often the config file is checked for changes
+const configPollInterval = 2 * time.Second
+
+// Config is the configuration file of the server
+type Config struct {
+	Server ServerConfig `yaml:"server"`
+	Auth   AuthConfig   `yaml:"auth"`
+	Log    LogConfig    `yaml:"log"`
+	// Profiles are the session profiles, ProfilesFile is read if it's empty
+	Profiles          []Profile       `yaml:"profiles"`
+	ProfilesFile      string          `yaml:"profilesFile"`
+	SeccompProfileDir string          `yaml:"seccompProfileDir"`
+	Env               EnvPolicy       `yaml:"env"`
+	Cgroup            CgroupConfig    `yaml:"cgroup"`
+	Limits            LimitConfig     `yaml:"limits"`
+	Recording         RecordingConfig `yaml:"recording"`
+	Redaction         RedactionConfig `yaml:"redaction"`
+	StoreDir          string          `yaml:"storeDir"`
+	Jobs              JobConfig       `yaml:"jobs"`
+	SchedulesFile     string          `yaml:"schedulesFile"`
+	Hosts             HostsConfig     `yaml:"hosts"`
+}
+
+// ServerConfig is the listener of the exec server
+type ServerConfig struct {
+	// Port is the port of the exec server, a random one is used if it's zero
+	Port int `yaml:"port"`
+	// ShutdownGrace is how long the running sessions are waited for on exit
+	ShutdownGrace time.Duration `yaml:"shutdownGrace"`
+}
+
+// setting is a value which is replaced when the config is reloaded
+type setting[T any] struct {
+	value T
+	mutex sync.RWMutex
+}
+
+func (s *setting[T]) get() T {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.value
+}
+
+func (s *setting[T]) set(value T) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.value = value
+}
+
+// ReadConfig reads the YAML config file over the given config, the unknown
+// fields are errors
+func ReadConfig(file string, config *Config) (err error) {
+	var data []byte
+	if data, err = os.ReadFile(file); err != nil {
+		return
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", file, err)
+	}
+	if err = config.validate(); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", file, err)
+	}
+	return nil
+}
+
+// validate checks the settings which don't depend on the others
+func (c *Config) validate() (err error) {
+	if c.Server.Port < 0 || c.Server.Port > 65535 {
+		return fmt.Errorf("server.port: invalid port %d", c.Server.Port)
+	}
+	if c.Server.ShutdownGrace < 0 {
+		return errors.New("server.shutdownGrace: must not be negative")
+	}
+	if err = c.Auth.validate(); err != nil {
+		return fmt.Errorf("auth.%w", err)
+	}
+	if err = c.Log.validate(); err != nil {
+		return fmt.Errorf("log: %w", err)
+	}
+	if err = c.Limits.validate(); err != nil {
+		return fmt.Errorf("limits: %w", err)
+	}
+	if err = c.Cgroup.Limits.validate(); err != nil {
+		return fmt.Errorf("cgroup.limits.%w", err)
+	}
+	if _, err = c.Redaction.compile(); err != nil {
+		return fmt.Errorf("redaction.%w", err)
+	}
+	if c.Jobs.Retention < 0 || c.Jobs.Timeout < 0 || c.Jobs.MaxJobs < 0 {
+		return errors.New("jobs: retention, maxJobs and timeout must not be negative")
+	}
+	return
+}
+
+// policies are the settings of a server which apply to the new sessions and
+// commands, they're replaced as a whole when the config is reloaded
+type policies struct {
+	profiles  []Profile
+	seccomp   map[string]SeccompProfile
+	env       EnvPolicy
+	redaction []*regexp.Regexp
+	recording RecordingConfig
+	limits    LimitConfig
+}
+
+// newPolicies builds the policies of the config, the recording directory is
+// created if it does not exist. The profiles are checked against the seccomp
+// profiles of the same config.
+func newPolicies(config Config) (p *policies, err error) {
+	p = &policies{env: config.Env, recording: config.Recording, limits: config.Limits}
+	if p.redaction, err = config.Redaction.compile(); err != nil {
+		return nil, fmt.Errorf("redaction.%w", err)
+	}
+	if p.seccomp, err = readSeccompProfiles(config.SeccompProfileDir); err != nil {
+		return nil, fmt.Errorf("seccompProfileDir: %w", err)
+	}
+
+	items := config.Profiles
+	if len(items) > 0 {
+		if err = validateProfiles(items, p.seccomp); err != nil {
+			return nil, fmt.Errorf("profiles: %w", err)
+		}
+	} else if config.ProfilesFile != "" {
+		if items, err = loadProfiles(config.ProfilesFile, p.seccomp); err != nil {
+			return nil, fmt.Errorf("profilesFile: %w", err)
+		}
+	}
+	p.profiles = withDefaultProfile(items)
+
+	if config.Recording.Dir != "" {
+		if err = os.MkdirAll(config.Recording.Dir, 0750); err != nil {
+			return nil, fmt.Errorf("recording: %w", err)
+		}
+	}
+	return
+}
+
+// setPolicies replaces the policies of the new sessions and commands
+func (s *ExecServer) setPolicies(p *policies) {
+	s.policies.set(p)
+	s.commandLimiter.setLimits(p.limits.Commands, p.limits.CommandsPerUser, p.limits.Queue)
+	s.streamLimiter.setLimits(p.limits.Streams, p.limits.StreamsPerUser, p.limits.Queue)
+	s.sessionLimiter.setLimits(p.limits.Sessions, p.limits.SessionsPerUser, 0)
+	s.updateSecrets()
+}
+
+// ReloadConfig applies the reloaded config to the new sessions, the running
+// ones are kept. The listener, the directories and the log format take
+// effect after a restart. Everything is read and checked before any of it is
+// applied, so the current config is kept as a whole when it fails.
+func (s *ExecServer) ReloadConfig(config Config) (err error) {
+	if err = config.validate(); err != nil {
+		return
+	}
+	current := s.config.get()
+	restart := map[string]bool{
+		"server":        config.Server != current.Server,
+		"log.format":    config.Log.Format != current.Log.Format,
+		"cgroup.root":   config.Cgroup.Root != current.Cgroup.Root,
+		"storeDir":      config.StoreDir != current.StoreDir,
+		"jobs.dir":      config.Jobs.Dir != current.Jobs.Dir,
+		"schedulesFile": config.SchedulesFile != current.SchedulesFile,
+		"hosts":         config.Hosts != current.Hosts,
+	}
+	for name, changed := range restart {
+		if changed {
+			s.logger.Warn("the change of the config takes effect after a restart", "setting", name)
+		}
+	}
+	config.Server, config.Log.Format, config.Cgroup.Root = current.Server, current.Log.Format, current.Cgroup.Root
+	config.StoreDir, config.Jobs.Dir, config.SchedulesFile = current.StoreDir, current.Jobs.Dir, current.SchedulesFile
+	config.Hosts = current.Hosts
+
+	level, err := config.Log.level()
+	if err != nil {
+		return
+	}
+	p, err := newPolicies(config)
+	if err != nil {
+		return
+	}
+
+	// the cgroup root is kept, its controllers are enabled already
+	s.logLevel.Set(level)
+	s.setPolicies(p)
+	s.jobs.setLimits(config.Jobs)
+	s.config.set(config)
+	s.logger.Info("config reloaded", "changed", !reflect.DeepEqual(config, current))
+	return
+}
+
+// WatchConfig reloads the config when the process receives SIGHUP, or the
+// file is changed. The load function reads the config again, the current
+// config is kept when it fails.
+func (s *ExecServer) WatchConfig(file string, load func() (Config, error)) {
+	signals := make(chan os.Signal, 1)
+	if len(reloadSignals) > 0 {
+		signal.Notify(signals, reloadSignals...)
+	}
+
+	modified := fileVersion(file)
+	ticker := time.NewTicker(configPollInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-signals:
+			case <-ticker.C:
+				version := fileVersion(file)
+				if version == modified {
+					continue
+				}
+				modified = version
+			case <-s.ctx.Done():
+				signal.Stop(signals)
+				return
+			}
+
+			config, err := load()
+			if err == nil {
+				err = s.ReloadConfig(config)
+			}
+			if err != nil {
+				s.logger.Error("failed to reload the config, the current one is kept", "file", file, "error", err)
+			}
+		}
+	}()
+}
+
+// fileVersion changes when the file is written
+func fileVersion(file string) string {
+	info, err := os.Stat(file)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+}
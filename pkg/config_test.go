@@ -0,0 +1,95 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadConfig(t *testing.T) {
+	server := newTestServer(t, &FakeRunner{})
+	config := server.config.get()
+	// another server of the same process keeps its own settings
+	other := newTestServer(t, &FakeRunner{})
+
+	t.Run("failed", func(t *testing.T) {
+		changed := config
+		changed.Log.Level = "debug"
+		changed.Limits.Commands = 3
+		changed.Auth.Tokens = []AuthToken{{User: "alice", Token: "alice-token"}}
+		changed.SeccompProfileDir = filepath.Join(t.TempDir(), "missing")
+
+		assert.Error(t, server.ReloadConfig(changed))
+		assert.Equal(t, slog.LevelInfo, server.logLevel.Level())
+		assert.Empty(t, server.config.get().Auth.Tokens)
+		assert.Equal(t, 0, server.policies.get().limits.Commands)
+		assert.Equal(t, config, server.config.get())
+	})
+
+	t.Run("succeeded", func(t *testing.T) {
+		changed := config
+		changed.Log.Level = "debug"
+		changed.Limits.Commands = 3
+		changed.Profiles = []Profile{{Name: "sh", Command: "/bin/sh"}}
+		changed.Auth.Tokens = []AuthToken{{User: "alice", Token: "alice-token"}}
+
+		require.NoError(t, server.ReloadConfig(changed))
+		assert.Equal(t, slog.LevelDebug, server.logLevel.Level())
+		assert.Equal(t, 3, server.policies.get().limits.Commands)
+		_, err := server.getProfile("sh")
+		assert.NoError(t, err)
+		assert.Equal(t, changed.Auth, server.config.get().Auth)
+
+		assert.Equal(t, slog.LevelInfo, other.logLevel.Level())
+		assert.Equal(t, 0, other.policies.get().limits.Commands)
+		assert.Empty(t, other.config.get().Auth.Tokens)
+	})
+
+	t.Run("seccomp profile added and removed", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "strict.yaml"), []byte("defaultAction: SCMP_ACT_ALLOW\n"), 0644))
+		changed := config
+		changed.SeccompProfileDir = dir
+		changed.Profiles = []Profile{{Name: "strict", Seccomp: "strict"}}
+
+		// the profile uses the seccomp profile which is added by the same reload
+		require.NoError(t, server.ReloadConfig(changed))
+		assert.Contains(t, server.policies.get().seccomp, "strict")
+		assert.NotContains(t, other.policies.get().seccomp, "strict")
+
+		// the seccomp profile is still used
+		require.NoError(t, os.Remove(filepath.Join(dir, "strict.yaml")))
+		assert.ErrorContains(t, server.ReloadConfig(changed), `seccomp profile "strict" not found`)
+		assert.Contains(t, server.policies.get().seccomp, "strict")
+	})
+
+	t.Run("invalid cgroup limits", func(t *testing.T) {
+		changed := config
+		changed.Cgroup.Limits.MemoryMax = "512 MB"
+		assert.ErrorContains(t, server.ReloadConfig(changed), `cgroup.limits.memoryMax: invalid value "512 MB"`)
+
+		changed = config
+		changed.Profiles = []Profile{{Name: "small", Limits: &CgroupLimits{CPUMax: "0 100000"}}}
+		assert.ErrorContains(t, server.ReloadConfig(changed), `profiles[0].limits.cpuMax: invalid value "0 100000"`)
+		assert.Equal(t, config.Cgroup, server.config.get().Cgroup)
+	})
+}
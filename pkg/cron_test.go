@@ -0,0 +1,151 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2025, time.January, 1, 10, 30, 20, 0, time.UTC)
+	tests := []struct {
+		spec       string
+		expectNext time.Time
+	}{{
+		spec:       "* * * * *",
+		expectNext: time.Date(2025, time.January, 1, 10, 31, 0, 0, time.UTC),
+	}, {
+		spec:       "10 * * * *",
+		expectNext: time.Date(2025, time.January, 1, 11, 10, 0, 0, time.UTC),
+	}, {
+		spec:       "*/15 * * * *",
+		expectNext: time.Date(2025, time.January, 1, 10, 45, 0, 0, time.UTC),
+	}, {
+		spec:       "40/7 * * * *",
+		expectNext: time.Date(2025, time.January, 1, 10, 40, 0, 0, time.UTC),
+	}, {
+		spec:       "5-10/2 * * * *",
+		expectNext: time.Date(2025, time.January, 1, 11, 5, 0, 0, time.UTC),
+	}, {
+		spec:       "0,45 10,12 * * *",
+		expectNext: time.Date(2025, time.January, 1, 10, 45, 0, 0, time.UTC),
+	}, {
+		spec:       "0 9 * * mon-fri",
+		expectNext: time.Date(2025, time.January, 2, 9, 0, 0, 0, time.UTC),
+	}, {
+		spec:       "0 0 * * 7",
+		expectNext: time.Date(2025, time.January, 5, 0, 0, 0, 0, time.UTC),
+	}, {
+		spec:       "0 0 * * SUN",
+		expectNext: time.Date(2025, time.January, 5, 0, 0, 0, 0, time.UTC),
+	}, {
+		spec:       "0 0 1 jan *",
+		expectNext: time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}, {
+		spec:       "0 0 1 Mar-May/2 *",
+		expectNext: time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC),
+	}, {
+		spec:       "0 0 13 * *",
+		expectNext: time.Date(2025, time.January, 13, 0, 0, 0, 0, time.UTC),
+	}, {
+		// either the 13th or a Friday, when both of them are restricted
+		spec:       "0 0 13 * fri",
+		expectNext: time.Date(2025, time.January, 3, 0, 0, 0, 0, time.UTC),
+	}, {
+		spec:       "0 0 13 * ?",
+		expectNext: time.Date(2025, time.January, 13, 0, 0, 0, 0, time.UTC),
+	}, {
+		spec:       "0 0 29 2 *",
+		expectNext: time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC),
+	}, {
+		spec: "0 0 30 2 *",
+	}, {
+		spec:       "@hourly",
+		expectNext: time.Date(2025, time.January, 1, 11, 0, 0, 0, time.UTC),
+	}, {
+		spec:       "@Daily",
+		expectNext: time.Date(2025, time.January, 2, 0, 0, 0, 0, time.UTC),
+	}, {
+		spec:       "@weekly",
+		expectNext: time.Date(2025, time.January, 5, 0, 0, 0, 0, time.UTC),
+	}, {
+		spec:       "@monthly",
+		expectNext: time.Date(2025, time.February, 1, 0, 0, 0, 0, time.UTC),
+	}}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			schedule, err := ParseCron(tt.spec)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectNext, schedule.Next(now))
+		})
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	tests := []struct {
+		spec        string
+		expectError string
+	}{{
+		spec:        "* * * *",
+		expectError: `invalid cron expression "* * * *": expected 5 fields`,
+	}, {
+		spec:        "@every 5m",
+		expectError: `invalid cron expression "@every 5m": expected 5 fields`,
+	}, {
+		spec:        "60 * * * *",
+		expectError: `invalid cron expression "60 * * * *": "60" is not in 0-59`,
+	}, {
+		spec:        "* 24 * * *",
+		expectError: `invalid cron expression "* 24 * * *": "24" is not in 0-23`,
+	}, {
+		spec:        "* * 0 * *",
+		expectError: `invalid cron expression "* * 0 * *": "0" is not in 1-31`,
+	}, {
+		spec:        "* * * 13 *",
+		expectError: `invalid cron expression "* * * 13 *": "13" is not in 1-12`,
+	}, {
+		spec:        "* * * * 8",
+		expectError: `invalid cron expression "* * * * 8": "8" is not in 0-7`,
+	}, {
+		spec:        "* * * foo *",
+		expectError: `invalid cron expression "* * * foo *": "foo" is not in 1-12`,
+	}, {
+		spec:        "* * * * mon-funday",
+		expectError: `invalid cron expression "* * * * mon-funday": "funday" is not in 0-7`,
+	}, {
+		spec:        "*/0 * * * *",
+		expectError: `invalid cron expression "*/0 * * * *": invalid step: "*/0"`,
+	}, {
+		spec:        "*/x * * * *",
+		expectError: `invalid cron expression "*/x * * * *": invalid step: "*/x"`,
+	}, {
+		spec:        "30-10 * * * *",
+		expectError: `invalid cron expression "30-10 * * * *": invalid range: "30-10"`,
+	}, {
+		spec:        "1,,2 * * * *",
+		expectError: `invalid cron expression "1,,2 * * * *": "" is not in 0-59`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := ParseCron(tt.spec)
+			assert.EqualError(t, err, tt.expectError)
+		})
+	}
+}
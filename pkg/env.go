@@ -0,0 +1,189 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// secretMask replaces the value of secret variables in the API responses
+const secretMask = "******"
+
+// EnvPolicy decides the environment of the spawned shells and commands. They
+// start from a minimal clean environment instead of inheriting the one of
+// the extension process, which might contain credentials.
+type EnvPolicy struct {
+	// Inherit keeps the full environment of the extension process
+	Inherit bool `json:"inherit,omitempty" yaml:"inherit,omitempty"`
+	// PassThrough lists the variables copied from the extension process, a
+	// trailing * matches a prefix, for instance: LC_*
+	PassThrough []string `json:"passThrough,omitempty" yaml:"passThrough,omitempty"`
+	// Set are injected into the environment
+	Set map[string]string `json:"set,omitempty" yaml:"set,omitempty"`
+	// Secrets lists the variables whose values are never returned by the API
+	Secrets []string `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+}
+
+// RedactionConfig masks more values in the output besides the ones of the
+// secret variables
+type RedactionConfig struct {
+	// Patterns are regular expressions, the matched text is masked
+	Patterns []string `json:"patterns,omitempty" yaml:"patterns,omitempty"`
+}
+
+func (c RedactionConfig) compile() (patterns []*regexp.Regexp, err error) {
+	for i, pattern := range c.Patterns {
+		var re *regexp.Regexp
+		if re, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("patterns[%d]: %w", i, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return
+}
+
+// baseEnvNames are the variables a shell needs to work properly
+func baseEnvNames() []string {
+	if runtime.GOOS == "windows" {
+		return []string{"PATH", "PATHEXT", "SYSTEMROOT", "SYSTEMDRIVE", "WINDIR", "COMSPEC",
+			"TEMP", "TMP", "USERNAME", "USERPROFILE", "APPDATA", "LOCALAPPDATA"}
+	}
+	return []string{"HOME", "PATH", "LANG", "TERM", "USER", "SHELL"}
+}
+
+// environ returns the environment in the form of key=value
+func (p EnvPolicy) environ() []string {
+	env := p.envMap()
+	result := make([]string, 0, len(env))
+	for key, value := range env {
+		result = append(result, key+"="+value)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (p EnvPolicy) envMap() map[string]string {
+	env := map[string]string{}
+	if p.Inherit {
+		for _, item := range os.Environ() {
+			if key, value, ok := strings.Cut(item, "="); ok {
+				env[key] = value
+			}
+		}
+	} else {
+		for _, key := range baseEnvNames() {
+			if value, ok := os.LookupEnv(key); ok {
+				env[key] = value
+			}
+		}
+		if runtime.GOOS != "windows" {
+			if _, ok := env["PATH"]; !ok {
+				env["PATH"] = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+			}
+			env["TERM"] = "xterm-256color"
+		}
+
+		for _, item := range os.Environ() {
+			if key, value, ok := strings.Cut(item, "="); ok && matchEnvName(p.PassThrough, key) {
+				env[key] = value
+			}
+		}
+	}
+
+	for key, value := range p.Set {
+		env[key] = value
+	}
+	return env
+}
+
+func (p EnvPolicy) isSecret(key string) bool {
+	return matchEnvName(p.Secrets, key)
+}
+
+// updateSecrets collects the values of the secret variables from the policy,
+// the profiles and the stored hosts. The short values are skipped since
+// replacing them would garble the normal output.
+func (s *ExecServer) updateSecrets() {
+	p := s.policies.get()
+	var pairs []string
+	collect := func(env map[string]string) {
+		for key, value := range env {
+			if p.env.isSecret(key) && len(value) >= 4 {
+				pairs = append(pairs, value, secretMask)
+			}
+		}
+	}
+	collect(p.env.envMap())
+	for _, profile := range p.profiles {
+		collect(profile.Env)
+	}
+	s.hosts.mutex.RLock()
+	for _, host := range s.hosts.hosts {
+		collect(host.Env)
+	}
+	s.hosts.mutex.RUnlock()
+	s.secrets.set(strings.NewReplacer(pairs...))
+}
+
+// redact masks the values of the secret variables, and the text matched by
+// the redaction patterns in the output
+func (s *ExecServer) redact(output string) string {
+	output = s.secrets.get().Replace(output)
+	for _, pattern := range s.policies.get().redaction {
+		output = pattern.ReplaceAllString(output, secretMask)
+	}
+	return output
+}
+
+func matchEnvName(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if pattern == key {
+			return true
+		}
+	}
+	return false
+}
+
+// handleEnv returns the environment of the new sessions, the secret values are masked
+func (s *ExecServer) handleEnv(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	policy := s.policies.get().env
+	env := policy.envMap()
+	for key := range env {
+		if policy.isSecret(key) {
+			env[key] = secretMask
+		}
+	}
+	_ = json.NewEncoder(w).Encode(env)
+}
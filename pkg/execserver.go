@@ -5,19 +5,24 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/creack/pty"
 	"io"
-	"log"
+	"log/slog"
+	"math"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/ssh"
 )
 
 type Terminal struct {
@@ -28,28 +33,40 @@ type Terminal struct {
 }
 
 type execRequest struct {
-	Cmd string `json:"cmd"`
+	Cmd            string      `json:"cmd"`
+	Profile        string      `json:"profile,omitempty"`
+	Isolation      *Isolation  `json:"isolation,omitempty"`
+	SeccompProfile string      `json:"seccompProfile,omitempty"`
+	Record         bool        `json:"record,omitempty"`
+	Assertions     []Assertion `json:"assertions,omitempty"`
 	Terminal
 }
 
+// withProfile fills the policies which are not set in the request from the profile
+func (r *execRequest) withProfile(profile Profile) {
+	if r.Isolation == nil {
+		r.Isolation = profile.Isolation
+	}
+	if r.SeccompProfile == "" {
+		r.SeccompProfile = profile.Seccomp
+	}
+	r.Record = r.Record || profile.Record
+}
+
 type inputRequest struct {
 	Input string `json:"input"`
 }
 
 type execResponse struct {
-	Stdout   string `json:"stdout"`
-	Stderr   string `json:"stderr"`
-	ExitCode int    `json:"exitCode"`
-	Error    string `json:"error,omitempty"`
-}
-
-// WebSocket message types
-type WSMessage struct {
-	Type     string `json:"type"`
-	Data     string `json:"data,omitempty"`
-	Pid      int    `json:"pid,omitempty"`
-	ExitCode int    `json:"exitCode,omitempty"`
-	Error    string `json:"error,omitempty"`
+	Stdout     string            `json:"stdout"`
+	Stderr     string            `json:"stderr"`
+	ExitCode   int               `json:"exitCode"`
+	Error      string            `json:"error,omitempty"`
+	Duration   string            `json:"duration"`
+	Passed     *bool             `json:"passed,omitempty"`
+	Assertions []AssertionResult `json:"assertions,omitempty"`
+
+	elapsed time.Duration
 }
 
 // ProcessManager manages running processes
@@ -66,11 +83,6 @@ type ProcessInfo struct {
 	Stderr *bufio.Reader
 }
 
-// Global process manager
-var processManager = &ProcessManager{
-	processes: make(map[int]*ProcessInfo),
-}
-
 type TerminalCache struct {
 	Writer         io.WriteCloser
 	Context        context.Context
@@ -79,500 +91,613 @@ type TerminalCache struct {
 	Terminal
 }
 
-// WebSocket upgrader
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow connections from any origin in this example
-	},
+// execTimeout is the timeout of the one-shot commands of /api/exec
+const execTimeout = 30 * time.Second
+
+// errShuttingDown is returned to the new sessions while the server drains
+var errShuttingDown = errors.New("the server is shutting down")
+
+// Clock tells the time, it's replaceable for the tests
+type Clock interface {
+	Now() time.Time
 }
 
-var serverPort int
+type systemClock struct{}
 
-func SetServerPort(port int) {
-	serverPort = port
+func (systemClock) Now() time.Time {
+	return time.Now()
 }
 
-// StartExecServer starts a small HTTP server to execute shell commands.
-// It runs in a goroutine and allows cross-origin requests (for local dev).
-func StartExecServer(addr string) net.Listener {
-	mux := http.NewServeMux()
+// ExecServer is the HTTP server of the terminal commands and sessions
+type ExecServer struct {
+	listener  net.Listener
+	server    *http.Server
+	handler   http.Handler
+	config    setting[Config]
+	clock     Clock
+	runner    CommandRunner
+	logger    *slog.Logger
+	logLevel  *slog.LevelVar
+	processes *ProcessManager
+	upgrader  websocket.Upgrader
+
+	// the policies of the new sessions and commands, and the secret values
+	// which are masked in the output
+	policies setting[*policies]
+	secrets  setting[*strings.Replacer]
+
+	// the limiters of the one-shot commands, the SSE streams and the PTY sessions
+	commandLimiter *limiter
+	streamLimiter  *limiter
+	sessionLimiter *limiter
+
+	sessionManager  *SessionManager
+	jobs            *JobManager
+	scheduler       *Scheduler
+	suites          *suiteStore
+	hosts           *hostStore
+	metrics         *metrics
+	metricsRegistry *prometheus.Registry
+
+	// the streaming commands which accept more input, keyed by the terminal ID
+	terminals      map[string]TerminalCache
+	terminalsMutex sync.Mutex
+
+	// ctx is cancelled once the sessions are not drained in time
+	ctx      context.Context
+	cancel   context.CancelFunc
+	sessions sync.WaitGroup
+	closing  bool
+	// shutdown is closed once the server starts shutting down, the sessions
+	// are killed at the deadline
+	shutdown chan struct{}
+	deadline time.Time
+	mutex    sync.Mutex
+}
 
-	mux.HandleFunc("/api/exec", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+// ExecServerOption configures an ExecServer
+type ExecServerOption func(*ExecServer)
 
-		if r.Method == http.MethodOptions {
-			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+// WithListener serves on the given listener instead of listening on the port of the config
+func WithListener(listener net.Listener) ExecServerOption {
+	return func(s *ExecServer) {
+		s.listener = listener
+	}
+}
 
-		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
+// WithConfig sets the config, the server listens on its port. The profiles,
+// the policies, the tokens, the logs, the cgroups, the directories and the
+// files of the server are set from it.
+func WithConfig(config Config) ExecServerOption {
+	return func(s *ExecServer) {
+		s.config.set(config)
+	}
+}
 
-		var req execRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
+// WithClock sets the clock of the command durations
+func WithClock(clock Clock) ExecServerOption {
+	return func(s *ExecServer) {
+		s.clock = clock
+	}
+}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
+// WithRunner sets the runner which starts the processes of the commands and the sessions
+func WithRunner(runner CommandRunner) ExecServerOption {
+	return func(s *ExecServer) {
+		s.runner = runner
+	}
+}
 
-		// Use shell to run the command so complex commands work.
-		cmd := createCommand(ctx, req.Cmd)
-		var stdout, stderr bytes.Buffer
-		cmd.Stdout = &stdout
-		cmd.Stderr = &stderr
+// WithLogger sets the logger of the server instead of the one of the log config
+func WithLogger(log *slog.Logger) ExecServerOption {
+	return func(s *ExecServer) {
+		s.logger = log
+	}
+}
+
+// NewExecServer creates the server, it listens right away so that the port
+// is known before it serves. The jobs of the last run, the schedules and the
+// stored hosts are loaded from the config.
+func NewExecServer(options ...ExecServerOption) (s *ExecServer, err error) {
+	metrics := newMetrics()
+	s = &ExecServer{
+		clock:    systemClock{},
+		runner:   NewLocalRunner(),
+		logLevel: new(slog.LevelVar),
+		processes: &ProcessManager{
+			processes: make(map[int]*ProcessInfo),
+		},
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true // Allow connections from any origin in this example
+			},
+		},
+		terminals:      map[string]TerminalCache{},
+		shutdown:       make(chan struct{}),
+		commandLimiter: newLimiter("commands", metrics),
+		streamLimiter:  newLimiter("streams", metrics),
+		sessionLimiter: newLimiter("sessions", metrics),
+		sessionManager: newSessionManager(),
+		hosts:          newHostStore(),
+		metrics:        metrics,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	if s.logger == nil {
+		s.logger = newLogger(os.Stderr, s.config.get().Log.Format, s.logLevel)
+	}
+	s.metricsRegistry = newMetricsRegistry(s)
+	if err = s.load(s.config.get()); err != nil {
+		return nil, err
+	}
 
-		err := cmd.Run()
-		resp := execResponse{
-			Stdout: stdout.String(),
-			Stderr: stderr.String(),
+	if s.listener == nil {
+		address := fmt.Sprintf(":%d", s.config.get().Server.Port)
+		if s.listener, err = net.Listen("tcp", address); err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
 		}
-		if err != nil {
-			resp.Error = err.Error()
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				resp.ExitCode = exitErr.ExitCode()
-			} else {
-				resp.ExitCode = -1
-			}
-		} else {
-			resp.ExitCode = 0
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.jobs.cleanup(s.ctx)
+	s.handler = s.routes()
+	s.server = &http.Server{Handler: s.handler}
+	return
+}
+
+// load sets the log level, the cgroups, the policies, the suites, the jobs,
+// the schedules and the hosts of the server from the config
+func (s *ExecServer) load(config Config) (err error) {
+	if err = config.validate(); err != nil {
+		return
+	}
+	level, _ := config.Log.level()
+	s.logLevel.Set(level)
+	if config.Cgroup.Root != "" {
+		// the root cgroup must be writable by the current user
+		if err = enableCgroupControllers(config.Cgroup.Root); err != nil {
+			return fmt.Errorf("cgroup: %w", err)
 		}
+	}
+	var p *policies
+	if p, err = newPolicies(config); err != nil {
+		return
+	}
+	s.setPolicies(p)
+	if s.suites, err = newSuiteStore(config.StoreDir); err != nil {
+		return fmt.Errorf("storeDir: %w", err)
+	}
+	if s.jobs, err = newJobManager(config.Jobs, s.logger); err != nil {
+		return fmt.Errorf("jobs: %w", err)
+	}
+	s.scheduler = newScheduler(s.jobs, s.submitJob, s.getProfile, s.logger)
+	if err = s.scheduler.load(config.SchedulesFile); err != nil {
+		return fmt.Errorf("schedulesFile: %w", err)
+	}
+	if err = s.hosts.load(config.Hosts); err != nil {
+		return fmt.Errorf("hosts: %w", err)
+	}
+	s.updateSecrets()
+	return
+}
 
-		_ = json.NewEncoder(w).Encode(resp)
-	})
+func (s *ExecServer) routes() http.Handler {
+	mux := http.NewServeMux()
 
-	// WebSocket endpoint for command execution
-	mux.HandleFunc("/extensionProxy/terminal/ws", handleWebSocket)
+	mux.HandleFunc("/api/exec", s.handleExec)
 
-	cmdWriterCache := map[string]TerminalCache{}
+	// WebSocket endpoint for command execution
+	mux.HandleFunc("/extensionProxy/terminal/ws", s.handleWebSocket)
+	mux.HandleFunc("/extensionProxy/terminal/replay", s.handleReplay)
+
+	mux.HandleFunc("/api/env", s.handleEnv)
+	mux.HandleFunc("/extensionProxy/terminal/profiles", s.handleProfiles)
+	mux.HandleFunc("/extensionProxy/terminal/capabilities", s.handleCapabilities)
+	mux.HandleFunc("/api/sessions", s.handleListSessions)
+	mux.HandleFunc("/api/expect", s.handleExpect)
+	mux.HandleFunc("/api/scripts", s.handleScript)
+	mux.HandleFunc("/api/jobs", s.handleJobs)
+	mux.HandleFunc("/api/jobs/{id}", s.handleJob)
+	mux.HandleFunc("/api/jobs/{id}/cancel", s.handleCancelJob)
+	mux.HandleFunc("/api/jobs/{id}/logs", s.handleJobLogs)
+	mux.HandleFunc("/api/schedules", s.handleSchedules)
+	mux.HandleFunc("/api/schedules/{name}", s.handleSchedule)
+	mux.HandleFunc("/api/schedules/{name}/run", s.handleRunSchedule)
+	mux.HandleFunc("/api/hosts", s.handleHosts)
+	mux.HandleFunc("/api/hosts/{name}", s.handleHost)
+	mux.HandleFunc("/api/hosts/{name}/hostkey", s.handleHostKey)
+	mux.HandleFunc("/api/suites/{suite}/cases/{case}/run", s.handleRunCase)
+	mux.HandleFunc("/api/sessions/{id}/stats", s.handleSessionStats)
+	mux.HandleFunc("/api/sessions/{id}/recording", s.handleSessionRecording)
+	mux.HandleFunc("/api/sessions/{id}/resize", s.handleSessionResize)
+	mux.HandleFunc("/api/sessions/{id}/signal", s.handleSessionSignal)
+	mux.HandleFunc("/api/sessions/{id}/snapshot", s.handleSessionSnapshot)
+	mux.HandleFunc("/api/recordings", s.handleListRecordings)
+	mux.HandleFunc("/api/recordings/{name}", s.handleRecording)
+	mux.Handle("/metrics", s.metricsHandler())
 
 	// Add streaming endpoint
-	mux.HandleFunc("/extensionProxy/terminal/exec", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodOptions {
-			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+	mux.HandleFunc("/extensionProxy/terminal/exec", s.handleStream)
 
-		var req execRequest
-
-		if r.Method == http.MethodDelete {
-			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			fmt.Println("terminating terminal", req.TerminalId)
-			if c, ok := cmdWriterCache[req.TerminalId]; ok {
-				c.Writer.Close()
-				c.Context.Done()
-				delete(cmdWriterCache, req.TerminalId)
-			}
-			return
-		} else if r.Method == http.MethodGet {
-			// get the keys of the map cmdWriterCache
-			keys := make([]Terminal, 0, len(cmdWriterCache))
-			for _, c := range cmdWriterCache {
-				keys = append(keys, Terminal{
-					TerminalId:   c.TerminalId,
-					TerminalName: c.TerminalName,
-					Mode:         runtime.GOOS,
-					WSPort:       serverPort,
-				})
-			}
-			if len(keys) == 0 {
-				keys = []Terminal{
-					{
-						TerminalId:   "default",
-						TerminalName: "Default",
-						WSPort:       serverPort,
-						Mode:         runtime.GOOS,
-					},
-				}
-			}
-			w.Header().Set("Content-Type", "application/json")
-			err := json.NewEncoder(w).Encode(keys)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-			}
-			return
-		} else if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
+	// Add endpoint for sending input to running process
+	mux.HandleFunc("/api/exec/input", s.handleExecInput)
 
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("X-Accel-Buffering", "no") // Disable buffering for nginx
-		if c, ok := cmdWriterCache[req.TerminalId]; ok {
-			fmt.Println("sending command to existing terminal", req.TerminalId, "cmd:", req.Cmd)
-			c.ResponseWriter = w
-			_, err := c.Writer.Write([]byte(req.Cmd + "\n"))
-			if err == nil {
-				return
-			} else {
-				fmt.Println("failed to write to terminal", req.TerminalId, "cmd:", req.Cmd, "error:", err)
-				go c.Context.Done()
-				c.DoneChannel <- true
-				delete(cmdWriterCache, req.TerminalId)
-			}
-		}
+	return s.withAuth(withRequestLogger(s.logger, mux))
+}
 
-		// Create context with timeout
-		ctx, cancel := context.WithCancel(context.Background()) // No timeout for interactive commands
-		defer cancel()
+// Handler returns the HTTP handler of the server
+func (s *ExecServer) Handler() http.Handler {
+	return s.handler
+}
 
-		// Use shell to run the command so complex commands work.
-		// For interactive commands like SSH, we need to allocate a pseudo-TTY
-		cmd := createCommand(ctx, req.Cmd)
+// Port returns the port which the server listens on
+func (s *ExecServer) Port() int {
+	if addr, ok := s.listener.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
 
-		// Check if this is an interactive command that needs a TTY
-		if isInteractiveCommand(req.Cmd) {
-			// Set environment variables to force TTY allocation
-			cmd.Env = append(os.Environ(), "TERM=xterm-256color")
-		}
+// Serve serves until the server is shut down
+func (s *ExecServer) Serve() (err error) {
+	if err = s.server.Serve(s.listener); errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return
+}
 
-		// Create stdin pipe to allow writing to the command
-		stdinPipe, err := cmd.StdinPipe()
-		if err != nil {
-			http.Error(w, "failed to create stdin pipe: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+// Shutdown stops accepting the connections and the new sessions, then tells
+// the clients how long the running sessions are waited for. The ones which
+// are still running once the context is done are killed with their process
+// groups, and their recordings are flushed.
+func (s *ExecServer) Shutdown(ctx context.Context) (err error) {
+	s.mutex.Lock()
+	if !s.closing {
+		s.closing = true
+		s.deadline, _ = ctx.Deadline()
+		close(s.shutdown)
+	}
+	s.mutex.Unlock()
+	s.logger.Info("shutting down", "deadline", s.deadline)
 
-		// Create pipes for stdout and stderr
-		stdoutPipe, err := cmd.StdoutPipe()
-		if err != nil {
-			http.Error(w, "failed to create stdout pipe: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+	// the WebSocket connections are hijacked, so they're not waited here
+	err = s.server.Shutdown(ctx)
 
-		stderrPipe, err := cmd.StderrPipe()
-		if err != nil {
-			http.Error(w, "failed to create stderr pipe: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+	drained := make(chan struct{})
+	go func() {
+		s.sessions.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		s.logger.Warn("killing the sessions which are still running")
+		err = ctx.Err()
+	}
+	s.cancel()
+	<-drained
+	return
+}
 
-		// Start the command
-		if err := cmd.Start(); err != nil {
-			http.Error(w, "failed to start command: "+err.Error(), http.StatusInternalServerError)
+// countdown sends the seconds which are left before the sessions are killed
+// once the server starts shutting down, more often as the deadline comes.
+// The seconds are 0 when there is no deadline. The channel is closed after
+// the last one, or once the context is done.
+func (s *ExecServer) countdown(ctx context.Context) <-chan int {
+	seconds := make(chan int)
+	go func() {
+		defer close(seconds)
+		select {
+		case <-s.shutdown:
+		case <-ctx.Done():
 			return
 		}
 
-		// Add process to manager
-		processInfo := &ProcessInfo{
-			Cmd:   cmd,
-			Stdin: bufio.NewWriter(stdinPipe),
-		}
-		processManager.mutex.Lock()
-		processManager.processes[cmd.Process.Pid] = processInfo
-		processManager.mutex.Unlock()
-
-		// Send initial message
-		fmt.Fprintf(w, "data: {\"type\": \"start\", \"pid\": %d}\n\n", cmd.Process.Pid)
-		w.(http.Flusher).Flush()
-
-		// Create scanners for stdout and stderr
-		stdoutScanner := bufio.NewScanner(stdoutPipe)
-		stderrScanner := bufio.NewScanner(stderrPipe)
-
-		// Channels for output
-		stdoutCh := make(chan string)
-		stderrCh := make(chan string)
-		doneCh := make(chan bool)
-
-		cmdWriterCache[req.TerminalId] = TerminalCache{
-			Writer:      stdinPipe,
-			Context:     ctx,
-			DoneChannel: doneCh,
-			Terminal: Terminal{
-				TerminalId:   req.TerminalId,
-				TerminalName: req.TerminalName,
-			},
-		}
-
-		// Variables to store exit code and error message
-		var exitCode int
-		var errorMsg string
-
-		// Goroutine for stdout
-		go func() {
-			for stdoutScanner.Scan() {
-				stdoutCh <- stdoutScanner.Text()
-			}
-			close(stdoutCh)
-		}()
-
-		// Goroutine for stderr
-		go func() {
-			for stderrScanner.Scan() {
-				stderrCh <- stderrScanner.Text()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for notified := false; ; notified = true {
+			left := 0
+			if !s.deadline.IsZero() {
+				left = max(int(math.Ceil(s.deadline.Sub(s.clock.Now()).Seconds())), 0)
 			}
-			close(stderrCh)
-		}()
-
-		// Goroutine to wait for command completion
-		go func() {
-			err := cmd.Wait()
-
-			exitCode = 0
-			if err != nil {
-				errorMsg = err.Error()
-				if exitErr, ok := err.(*exec.ExitError); ok {
-					exitCode = exitErr.ExitCode()
-				} else {
-					exitCode = -1
+			if !notified || left%10 == 0 || left <= 5 {
+				select {
+				case seconds <- left:
+				case <-ctx.Done():
+					return
 				}
 			}
-
-			// Remove process from manager
-			processManager.mutex.Lock()
-			delete(processManager.processes, cmd.Process.Pid)
-			processManager.mutex.Unlock()
-
-			doneCh <- true
-		}()
-
-		// Main loop to handle output and input
-		loop := true
-		for loop {
+			if left == 0 {
+				return
+			}
 			select {
-			case stdoutLine, ok := <-stdoutCh:
-				if ok {
-					_, e := fmt.Fprintf(w, "data: {\"type\": \"stdout\", \"data\": %q}\n\n", stdoutLine)
-					if e != nil {
-						fmt.Println("failed to write to terminal", req.TerminalId, "stdout:", e)
-					}
-					w.(http.Flusher).Flush()
-				}
-			case stderrLine, ok := <-stderrCh:
-				if ok {
-					fmt.Fprintf(w, "data: {\"type\": \"stderr\", \"data\": %q}\n\n", stderrLine)
-					w.(http.Flusher).Flush()
-				}
-				break
-			case <-doneCh:
-				// Command has finished executing, send final end event
-				fmt.Fprintf(w, "data: {\"type\": \"end\", \"exitCode\": %d, \"error\": %q}\n\n", exitCode, errorMsg)
-				w.(http.Flusher).Flush()
-				// Close stdin pipe
-				stdinPipe.Close()
-				loop = false
+			case <-ticker.C:
 			case <-ctx.Done():
-				// Context cancelled, kill the process
-				if cmd.Process != nil {
-					cmd.Process.Kill()
-				}
-				fmt.Fprintf(w, "data: {\"type\": \"error\", \"data\": \"Command cancelled\"}\n\n")
-				w.(http.Flusher).Flush()
-				// Close stdin pipe
-				stdinPipe.Close()
-				// Remove process from manager
-				processManager.mutex.Lock()
-				delete(processManager.processes, cmd.Process.Pid)
-				processManager.mutex.Unlock()
-				loop = false
+				return
 			}
 		}
-		delete(cmdWriterCache, req.TerminalId)
-		fmt.Println("command finished", req.TerminalId, "exitCode:", exitCode, "error:", errorMsg)
-	})
-
-	// Add endpoint for sending input to running process
-	mux.HandleFunc("/api/exec/input", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-
-		if r.Method == http.MethodOptions {
-			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+	}()
+	return seconds
+}
 
-		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
+// startSession counts a new session, which is refused once the server is shutting down
+func (s *ExecServer) startSession() (done func(), err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closing {
+		return nil, errShuttingDown
+	}
+	s.sessions.Add(1)
+	return s.sessions.Done, nil
+}
 
-		var req struct {
-			Pid   int    `json:"pid"`
-			Input string `json:"input"`
-		}
+// handleExec runs a one-shot command
+func (s *ExecServer) handleExec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-
-		// Find the process
-		processManager.mutex.RLock()
-		processInfo, exists := processManager.processes[req.Pid]
-		processManager.mutex.RUnlock()
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 
-		if !exists {
-			http.Error(w, "process not found", http.StatusNotFound)
-			return
-		}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-		// Write input to process stdin
-		_, err := processInfo.Stdin.WriteString(req.Input)
-		if err != nil {
-			http.Error(w, "failed to write to process stdin: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+	var req execRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		// Flush the buffer
-		err = processInfo.Stdin.Flush()
-		if err != nil {
-			http.Error(w, "failed to flush stdin buffer: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+	profile, err := s.getProfile(req.Profile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.withProfile(profile)
+	if err := validateAssertions(req.Assertions); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
-	})
+	release, err := s.commandLimiter.acquire(r.Context(), requestUser(r), nil)
+	if errors.Is(err, errQueueFull) {
+		loggerFrom(r.Context()).Warn("command rejected", "error", err)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	} else if err != nil {
+		// the client is gone while waiting
+		return
+	}
+	defer release()
 
-	lis, err := net.Listen("tcp", addr)
+	done, err := s.startSession()
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
 	}
+	defer done()
 
-	go func() {
-		if err := http.Serve(lis, mux); err != nil && err != http.ErrServerClosed {
-			log.Printf("exec server error: %v", err)
-		}
-	}()
-	return lis
-}
+	ctx, cancel := context.WithTimeout(s.ctx, execTimeout)
+	defer cancel()
 
-// handleWebSocket handles WebSocket connections for command execution
-func handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	cmd, code, err := prepareCommand(ctx, s.runner, s.policies.get(), req, profile)
 	if err != nil {
-		log.Printf("WebSocket upgrade failed: %v", err)
+		http.Error(w, err.Error(), code)
 		return
 	}
-	defer conn.Close()
-
-	shell := os.Getenv("SHELL")
-	if shell == "" {
-		switch runtime.GOOS {
-		case "windows":
-			shell = "powershell.exe"
-		default:
-			shell = "/bin/sh"
-		}
+	resp := s.runCommand(cmd, req)
+	resp.assert(req.Assertions)
+	log := loggerFrom(r.Context()).With("cmd", s.redact(req.Cmd))
+	// there is no process when the command failed to start
+	if cmd.Process != nil {
+		log = log.With("pid", cmd.Process.Pid)
 	}
-	cmd := exec.Command(shell)
-	ptmx, err := pty.Start(cmd)
-	if err != nil {
-		log.Printf("pty start shell: %s, err: %v", shell, err)
+	log.Info("command finished", "exit_code", resp.ExitCode, "duration", resp.Duration)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// handleStream runs a command and streams its output as server-sent events,
+// the command of an existing terminal is written to its stdin instead
+func (s *ExecServer) handleStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusOK)
 		return
 	}
-	defer func() { _ = ptmx.Close(); cmd.Process.Kill() }()
 
-	var wg sync.WaitGroup
-	wg.Add(2)
+	var req execRequest
 
-	// 2. WebSocket → pty
-	go func() {
-		defer wg.Done()
-		for {
-			_, msg, err := conn.ReadMessage()
-			if err != nil {
-				return
-			}
-			if _, err := ptmx.Write(msg); err != nil {
-				return
+	if r.Method == http.MethodDelete {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		loggerFrom(r.Context()).Info("terminating terminal", "terminal_id", req.TerminalId)
+		if c, ok := s.takeTerminal(req.TerminalId); ok {
+			c.Writer.Close()
+			c.Context.Done()
+		}
+		return
+	} else if r.Method == http.MethodGet {
+		keys := []Terminal{}
+		for _, c := range s.listTerminals() {
+			keys = append(keys, Terminal{
+				TerminalId:   c.TerminalId,
+				TerminalName: c.TerminalName,
+				Mode:         runtime.GOOS,
+				WSPort:       s.Port(),
+			})
+		}
+		if len(keys) == 0 {
+			keys = []Terminal{
+				{
+					TerminalId:   "default",
+					TerminalName: "Default",
+					WSPort:       s.Port(),
+					Mode:         runtime.GOOS,
+				},
 			}
 		}
-	}()
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(keys)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	} else if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-	// 3. pty → WebSocket
-	go func() {
-		defer wg.Done()
-		buf := make([]byte, 1024)
-		for {
-			n, err := ptmx.Read(buf)
-			if err != nil {
-				return
-			}
-			if err := conn.WriteMessage(websocket.TextMessage, buf[:n]); err != nil {
-				return
-			}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log := loggerFrom(r.Context()).With("terminal_id", req.TerminalId)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable buffering for nginx
+	if c, ok := s.getTerminal(req.TerminalId); ok {
+		log.Debug("sending command to existing terminal", "cmd", s.redact(req.Cmd))
+		c.ResponseWriter = w
+		n, err := c.Writer.Write([]byte(req.Cmd + "\n"))
+		s.metrics.countBytes(SessionTypeStream, directionIn, n)
+		if err == nil {
+			return
+		} else {
+			log.Warn("failed to write to terminal", "cmd", s.redact(req.Cmd), "error", err)
+			go c.Context.Done()
+			c.DoneChannel <- true
+			s.takeTerminal(req.TerminalId)
 		}
-	}()
+	}
 
-	// 4. 阻塞直到任一端断开
-	wg.Wait()
-}
+	profile, err := s.getProfile(req.Profile)
+	if err == nil {
+		err = profile.local()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.withProfile(profile)
 
-// executeCommandViaWS executes a command and streams output via WebSocket
-func executeCommandViaWS(conn *websocket.Conn, req execRequest) {
-	// Send start message
-	sendWSMessage(conn, WSMessage{
-		Type: "start",
+	release, err := s.streamLimiter.acquire(r.Context(), requestUser(r), func(position int) {
+		writeAndFlush(w, "data: {\"type\": \"queued\", \"position\": %d}\n\n", position)
 	})
+	if errors.Is(err, errQueueFull) {
+		log.Warn("command rejected", "error", err)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	} else if err != nil {
+		// the client is gone while waiting
+		return
+	}
+	defer release()
 
-	// Create context
-	ctx, cancel := context.WithCancel(context.Background())
+	done, err := s.startSession()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer done()
+
+	// Create context with timeout
+	ctx, cancel := context.WithCancel(s.ctx) // No timeout for interactive commands
 	defer cancel()
 
 	// Use shell to run the command so complex commands work.
-	cmd := createCommand(ctx, req.Cmd)
+	// For interactive commands like SSH, we need to allocate a pseudo-TTY
+	cmd := s.runner.Command(ctx, req.Cmd)
+	if err := profile.prepare(cmd, s.policies.get().env); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := applyIsolation(cmd, req.Isolation); err != nil {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	if err := applySeccomp(cmd, s.policies.get().seccomp, req.SeccompProfile); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	setProcessGroup(cmd)
 
 	// Check if this is an interactive command that needs a TTY
 	if isInteractiveCommand(req.Cmd) {
 		// Set environment variables to force TTY allocation
-		cmd.Env = append(os.Environ(), "TERM=xterm-256color")
+		cmd.Env = append(cmd.Env, "TERM=xterm-256color")
+	}
+
+	// Create stdin pipe to allow writing to the command
+	stdinPipe, err := cmd.StdinPipe()
+	if err != nil {
+		http.Error(w, "failed to create stdin pipe: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	// Create pipes for stdout and stderr
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
-		sendWSMessage(conn, WSMessage{
-			Type:  "error",
-			Error: "Failed to create stdout pipe: " + err.Error(),
-		})
+		http.Error(w, "failed to create stdout pipe: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	stderrPipe, err := cmd.StderrPipe()
 	if err != nil {
-		sendWSMessage(conn, WSMessage{
-			Type:  "error",
-			Error: "Failed to create stderr pipe: " + err.Error(),
-		})
+		http.Error(w, "failed to create stderr pipe: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	session, err := s.newSession(withLogger(r.Context(), log), req.TerminalId, SessionTypeStream, profile)
+	if errors.Is(err, errSessionExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to create session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer session.close()
+	session.prepare(cmd)
+
 	// Start the command
-	if err := cmd.Start(); err != nil {
-		sendWSMessage(conn, WSMessage{
-			Type:  "error",
-			Error: "Failed to start command: " + err.Error(),
-		})
+	if err := s.runner.Start(cmd); err != nil {
+		err = wrapIsolationError(req.Isolation, err)
+		http.Error(w, "failed to start command: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	session.register(cmd.Process.Pid)
+	finished := s.metrics.commandStarted(SessionTypeStream)
+	if req.Record {
+		recording := s.policies.get().recording
+		if _, err := session.startRecording(recording.Dir, recording.Input); err != nil {
+			writeAndFlush(w, "data: {\"type\": \"error\", \"data\": %q}\n\n", "failed to record the session: "+err.Error())
+		}
+	}
 
-	// Send PID
-	sendWSMessage(conn, WSMessage{
-		Type: "pid",
-		Pid:  cmd.Process.Pid,
-	})
+	// Add process to manager
+	processInfo := &ProcessInfo{
+		Cmd:   cmd,
+		Stdin: bufio.NewWriter(stdinPipe),
+	}
+	s.processes.mutex.Lock()
+	s.processes.processes[cmd.Process.Pid] = processInfo
+	s.processes.mutex.Unlock()
+
+	// Send initial message
+	fmt.Fprintf(w, "data: {\"type\": \"start\", \"pid\": %d}\n\n", cmd.Process.Pid)
+	w.(http.Flusher).Flush()
 
 	// Create scanners for stdout and stderr
 	stdoutScanner := bufio.NewScanner(stdoutPipe)
@@ -581,69 +706,421 @@ func executeCommandViaWS(conn *websocket.Conn, req execRequest) {
 	// Channels for output
 	stdoutCh := make(chan string)
 	stderrCh := make(chan string)
-	doneCh := make(chan int) // Channel to signal completion with exit code
+	doneCh := make(chan bool)
+
+	s.putTerminal(TerminalCache{
+		Writer:      stdinPipe,
+		Context:     ctx,
+		DoneChannel: doneCh,
+		Terminal: Terminal{
+			TerminalId:   req.TerminalId,
+			TerminalName: req.TerminalName,
+		},
+	})
+
+	// Variables to store exit code and error message
+	var exitCode int
+	var errorMsg string
+	var violation *SeccompViolationError
+
+	// the output is read to the end before the command is waited for,
+	// Wait closes the pipes
+	var readers sync.WaitGroup
+	readers.Add(2)
 
 	// Goroutine for stdout
 	go func() {
+		defer readers.Done()
 		for stdoutScanner.Scan() {
-			stdoutCh <- stdoutScanner.Text()
+			select {
+			case stdoutCh <- s.redact(stdoutScanner.Text()):
+			case <-ctx.Done():
+				return
+			}
 		}
 		close(stdoutCh)
 	}()
 
 	// Goroutine for stderr
 	go func() {
+		defer readers.Done()
 		for stderrScanner.Scan() {
-			stderrCh <- stderrScanner.Text()
+			select {
+			case stderrCh <- s.redact(stderrScanner.Text()):
+			case <-ctx.Done():
+				return
+			}
 		}
 		close(stderrCh)
 	}()
 
 	// Goroutine to wait for command completion
 	go func() {
+		readers.Wait()
 		err := cmd.Wait()
-		exitCode := 0
+
+		exitCode = 0
 		if err != nil {
+			errorMsg = err.Error()
+			if errors.As(s.checkSeccomp(req.SeccompProfile, err), &violation) {
+				errorMsg = violation.Error()
+			}
 			if exitErr, ok := err.(*exec.ExitError); ok {
 				exitCode = exitErr.ExitCode()
 			} else {
 				exitCode = -1
 			}
 		}
-		doneCh <- exitCode
+
+		finished(exitCode)
+
+		// Remove process from manager
+		s.processes.mutex.Lock()
+		delete(s.processes.processes, cmd.Process.Pid)
+		s.processes.mutex.Unlock()
+
+		doneCh <- true
 	}()
 
-	// Main loop to handle output
-	for {
+	countdown := s.countdown(ctx)
+
+	// Main loop to handle output and input
+	loop := true
+	for loop {
 		select {
+		case seconds, ok := <-countdown:
+			if !ok {
+				countdown = nil
+				continue
+			}
+			writeAndFlush(w, "data: {\"type\": \"shutdown\", \"seconds\": %d}\n\n", seconds)
 		case stdoutLine, ok := <-stdoutCh:
-			if ok {
-				sendWSMessage(conn, WSMessage{
-					Type: "stdout",
-					Data: stdoutLine,
-				})
+			if !ok {
+				stdoutCh = nil
+			} else {
+				session.output([]byte(stdoutLine + "\r\n"))
+				_, e := fmt.Fprintf(w, "data: {\"type\": \"stdout\", \"data\": %q}\n\n", stdoutLine)
+				if e != nil {
+					session.log.Warn("failed to write the stdout", "error", e)
+				}
+				w.(http.Flusher).Flush()
 			}
 		case stderrLine, ok := <-stderrCh:
-			if ok {
-				sendWSMessage(conn, WSMessage{
-					Type: "stderr",
-					Data: stderrLine,
-				})
+			if !ok {
+				stderrCh = nil
+			} else {
+				session.output([]byte(stderrLine + "\r\n"))
+				fmt.Fprintf(w, "data: {\"type\": \"stderr\", \"data\": %q}\n\n", stderrLine)
+				w.(http.Flusher).Flush()
 			}
-		case exitCode := <-doneCh:
-			// Command has finished executing
-			sendWSMessage(conn, WSMessage{
-				Type:     "end",
-				ExitCode: exitCode,
-			})
-			return
+			break
+		case <-doneCh:
+			if violation != nil {
+				writeAndFlush(w, "data: {\"type\": \"error\", \"data\": %q}\n\n", violation.Error())
+			}
+			// Command has finished executing, send final end event
+			fmt.Fprintf(w, "data: {\"type\": \"end\", \"exitCode\": %d, \"error\": %q}\n\n", exitCode, errorMsg)
+			w.(http.Flusher).Flush()
+			// Close stdin pipe
+			stdinPipe.Close()
+			loop = false
+		case <-ctx.Done():
+			// Context cancelled, kill the process
+			if cmd.Process != nil {
+				_ = killProcessGroup(cmd)
+			}
+			fmt.Fprintf(w, "data: {\"type\": \"error\", \"data\": \"Command cancelled\"}\n\n")
+			w.(http.Flusher).Flush()
+			// Close stdin pipe
+			stdinPipe.Close()
+			// Remove process from manager
+			s.processes.mutex.Lock()
+			delete(s.processes.processes, cmd.Process.Pid)
+			s.processes.mutex.Unlock()
+			loop = false
 		}
 	}
+	s.takeTerminal(req.TerminalId)
+	session.log.Info("command finished", "exit_code", exitCode, "error", errorMsg)
 }
 
-// sendWSMessage sends a message via WebSocket
-func sendWSMessage(conn *websocket.Conn, msg WSMessage) error {
-	return conn.WriteJSON(msg)
+// handleExecInput writes to the stdin of a streaming command
+func (s *ExecServer) handleExecInput(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Pid   int    `json:"pid"`
+		Input string `json:"input"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Find the process
+	s.processes.mutex.RLock()
+	processInfo, exists := s.processes.processes[req.Pid]
+	s.processes.mutex.RUnlock()
+
+	if !exists {
+		http.Error(w, "process not found", http.StatusNotFound)
+		return
+	}
+
+	// Write input to process stdin
+	n, err := processInfo.Stdin.WriteString(req.Input)
+	s.metrics.countBytes(SessionTypeStream, directionIn, n)
+	if err != nil {
+		http.Error(w, "failed to write to process stdin: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Flush the buffer
+	err = processInfo.Stdin.Flush()
+	if err != nil {
+		http.Error(w, "failed to flush stdin buffer: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// getTerminal returns the streaming command of the terminal
+func (s *ExecServer) getTerminal(id string) (terminal TerminalCache, ok bool) {
+	s.terminalsMutex.Lock()
+	defer s.terminalsMutex.Unlock()
+	terminal, ok = s.terminals[id]
+	return
+}
+
+// takeTerminal removes the streaming command of the terminal, and returns it
+func (s *ExecServer) takeTerminal(id string) (terminal TerminalCache, ok bool) {
+	s.terminalsMutex.Lock()
+	defer s.terminalsMutex.Unlock()
+	if terminal, ok = s.terminals[id]; ok {
+		delete(s.terminals, id)
+	}
+	return
+}
+
+func (s *ExecServer) putTerminal(terminal TerminalCache) {
+	s.terminalsMutex.Lock()
+	defer s.terminalsMutex.Unlock()
+	s.terminals[terminal.TerminalId] = terminal
+}
+
+func (s *ExecServer) listTerminals() (terminals []TerminalCache) {
+	s.terminalsMutex.Lock()
+	defer s.terminalsMutex.Unlock()
+	for _, terminal := range s.terminals {
+		terminals = append(terminals, terminal)
+	}
+	return
+}
+
+// handleWebSocket handles WebSocket connections for command execution
+func (s *ExecServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	release, ok := s.sessionLimiter.tryAcquire(requestUser(r))
+	if !ok {
+		loggerFrom(r.Context()).Warn("session rejected", "error", errSessionQuota)
+		http.Error(w, errSessionQuota.Error(), http.StatusTooManyRequests)
+		return
+	}
+	defer release()
+
+	done, err := s.startSession()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer done()
+
+	// it's checked again when the session is created
+	if id := r.URL.Query().Get("id"); id != "" && s.sessionManager.taken(id) {
+		http.Error(w, fmt.Errorf("%w: %s", errSessionExists, id).Error(), http.StatusConflict)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		loggerFrom(r.Context()).Warn("WebSocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+	defer s.metrics.websocketConnected("terminal")()
+
+	profile, err := s.sessionProfile(r.URL.Query().Get("profile"), r.URL.Query().Get("host"))
+	if err != nil {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(err.Error()+"\r\n"))
+		return
+	}
+	ctx := withTrustPrompt(r.Context(), promptHostKey(conn))
+	session, err := s.startPTYSession(ctx, r.URL.Query().Get("id"), profile, r.URL.Query().Get("seccomp"))
+	if err != nil {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(err.Error()+"\r\n"))
+		return
+	}
+	defer session.close()
+	shell := session.shell
+	defer shell.Close()
+	// the shell is killed once the server is not drained in time, the client
+	// is disconnected since it may not close the connection itself
+	defer context.AfterFunc(s.ctx, func() { _ = shell.Close(); _ = conn.Close() })()
+
+	if record := r.URL.Query().Get("record"); record == "true" || (profile.Record && record != "false") {
+		recording := s.policies.get().recording
+		if _, err := session.startRecording(recording.Dir, recording.Input); err != nil {
+			_ = conn.WriteMessage(websocket.TextMessage, []byte("failed to record the session: "+err.Error()+"\r\n"))
+		}
+	}
+
+	// the output and the shutdown notices are written by different goroutines
+	var writeMutex sync.Mutex
+	write := func(data []byte) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		return conn.WriteMessage(websocket.TextMessage, data)
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		for seconds := range s.countdown(ctx) {
+			_ = write([]byte(shutdownNotice(seconds)))
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// 2. WebSocket → pty
+	go func() {
+		defer wg.Done()
+		// the remote shells don't exit when the client is gone, it stops the reading below
+		defer shell.Close()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			session.input(msg)
+			if _, err := shell.Write(msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	// 3. pty → WebSocket
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, 1024)
+		for {
+			n, err := shell.Read(buf)
+			if err != nil {
+				var violation *SeccompViolationError
+				if errors.As(s.checkSeccomp(session.seccomp, shell.Wait()), &violation) {
+					_ = write([]byte("\r\n" + violation.Error() + "\r\n"))
+				}
+				return
+			}
+			// secrets split across two reads are not masked, it's best effort
+			data := []byte(s.redact(string(buf[:n])))
+			session.output(data)
+			if err := write(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	// 4. 阻塞直到任一端断开
+	wg.Wait()
+}
+
+// controlHostKey is the type of the control messages which ask whether an
+// unknown host key is trusted, and answer it
+const controlHostKey = "hostKey"
+
+// hostKeyPrompt asks the client whether an unknown host key is trusted. The
+// control messages are sent in binary frames and the keys in text frames, so
+// they don't mix.
+type hostKeyPrompt struct {
+	Type        string `json:"type"`
+	ID          string `json:"id"`
+	Host        string `json:"host"`
+	KeyType     string `json:"keyType"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// hostKeyReply is the answer of the client to the prompt of the same ID
+type hostKeyReply struct {
+	Type  string `json:"type"`
+	ID    string `json:"id"`
+	Trust bool   `json:"trust"`
+}
+
+// promptHostKey asks the client of the terminal whether an unknown host key
+// is trusted, the keys typed before the answer are dropped
+func promptHostKey(conn *websocket.Conn) trustPrompt {
+	return func(host string, key ssh.PublicKey) bool {
+		_ = conn.SetReadDeadline(time.Now().Add(hostKeyPromptTimeout))
+		defer conn.SetReadDeadline(time.Time{})
+
+		prompt := hostKeyPrompt{
+			Type:        controlHostKey,
+			ID:          uuid.NewString(),
+			Host:        host,
+			KeyType:     key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(key),
+		}
+		data, err := json.Marshal(prompt)
+		if err != nil {
+			return false
+		}
+		notice := fmt.Sprintf("The authenticity of host '%s' can't be established.\r\n%s key fingerprint is %s.\r\n",
+			host, prompt.KeyType, prompt.Fingerprint)
+		if conn.WriteMessage(websocket.TextMessage, []byte(notice)) != nil || conn.WriteMessage(websocket.BinaryMessage, data) != nil {
+			return false
+		}
+
+		for {
+			messageType, msg, err := conn.ReadMessage()
+			if err != nil {
+				return false
+			}
+			var reply hostKeyReply
+			if messageType != websocket.BinaryMessage || json.Unmarshal(msg, &reply) != nil ||
+				reply.Type != controlHostKey || reply.ID != prompt.ID {
+				continue
+			}
+			if reply.Trust {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("Warning: permanently added '%s' to the known hosts.\r\n", host)))
+			}
+			return reply.Trust
+		}
+	}
+}
+
+// shutdownNotice is written to the terminal sessions while the server is shutting down
+func shutdownNotice(seconds int) string {
+	if seconds == 0 {
+		return "\r\n[the server is shutting down]\r\n"
+	}
+	return fmt.Sprintf("\r\n[the server is shutting down, the session is closed in %ds]\r\n", seconds)
 }
 
 // isInteractiveCommand checks if a command is likely to be interactive
@@ -657,10 +1134,57 @@ func isInteractiveCommand(cmd string) bool {
 	return false
 }
 
-// createCommand creates an exec.Command based on the operating system
-func createCommand(ctx context.Context, cmdString string) *exec.Cmd {
-	if runtime.GOOS == "windows" {
-		return exec.CommandContext(ctx, "cmd.exe", "/c", cmdString)
+// prepareCommand creates the command of the request with the policies of the
+// profile, the HTTP status is returned with the error
+func prepareCommand(ctx context.Context, runner CommandRunner, p *policies, req execRequest, profile Profile) (cmd *exec.Cmd, code int, err error) {
+	if err = profile.local(); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	// Use shell to run the command so complex commands work.
+	cmd = runner.Command(ctx, req.Cmd)
+	if err = profile.prepare(cmd, p.env); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if err = applyIsolation(cmd, req.Isolation); err != nil {
+		return nil, http.StatusNotImplemented, err
+	}
+	if err = applySeccomp(cmd, p.seccomp, req.SeccompProfile); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	setProcessGroup(cmd)
+	return
+}
+
+// runCommand runs the command until it exits, the output is redacted
+func (s *ExecServer) runCommand(cmd *exec.Cmd, req execRequest) (resp execResponse) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	begin := s.clock.Now()
+	finished := s.metrics.commandStarted(transportExec)
+	err := s.runner.Start(cmd)
+	if err == nil {
+		err = cmd.Wait()
+	}
+	err = wrapIsolationError(req.Isolation, err)
+	resp = execResponse{
+		Stdout:  s.redact(stdout.String()),
+		Stderr:  s.redact(stderr.String()),
+		elapsed: s.clock.Now().Sub(begin),
+	}
+	resp.Duration = resp.elapsed.String()
+	if err != nil {
+		resp.Error = s.checkSeccomp(req.SeccompProfile, err).Error()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			resp.ExitCode = exitErr.ExitCode()
+		} else {
+			resp.ExitCode = -1
+		}
+	} else {
+		resp.ExitCode = 0
 	}
-	return exec.CommandContext(ctx, "sh", "-c", cmdString)
+	finished(resp.ExitCode)
+	s.metrics.countBytes(transportExec, directionOut, stdout.Len()+stderr.Len())
+	return
 }
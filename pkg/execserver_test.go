@@ -0,0 +1,268 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	RunFakeProcess()
+	// the commands with a seccomp profile start the test binary as the helper
+	if len(os.Args) > 1 && os.Args[1] == SeccompExecCommand {
+		if err := RunSeccompExec(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+// fakeClock moves forward a second each time it's read
+type fakeClock struct {
+	now   time.Time
+	mutex sync.Mutex
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(time.Second)
+	return c.now
+}
+
+// newTestServer creates a server whose files are in a temporary directory,
+// the config can be changed before the server is created
+func newTestServer(t *testing.T, runner CommandRunner, configure ...func(*Config)) *ExecServer {
+	t.Helper()
+	dir := t.TempDir()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	config := Config{
+		StoreDir:      filepath.Join(dir, "suites"),
+		Jobs:          JobConfig{Dir: filepath.Join(dir, "jobs")},
+		SchedulesFile: filepath.Join(dir, "schedules.yaml"),
+		Hosts: HostsConfig{
+			File:           filepath.Join(dir, "hosts.yaml"),
+			KeyFile:        filepath.Join(dir, "hosts.key"),
+			KnownHostsFile: filepath.Join(dir, "known_hosts"),
+		},
+	}
+	for _, item := range configure {
+		item(&config)
+	}
+	server, err := NewExecServer(WithConfig(config), WithListener(listener),
+		WithRunner(runner), WithClock(&fakeClock{}))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+		_ = server.DrainJobs(ctx)
+	})
+	return server
+}
+
+func TestHandleExec(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		body         string
+		runner       *FakeRunner
+		expectStatus int
+		expectResp   execResponse
+	}{{
+		name:         "succeeded",
+		method:       http.MethodPost,
+		body:         `{"cmd":"echo hello"}`,
+		runner:       &FakeRunner{ExpectStdout: "hello\n"},
+		expectStatus: http.StatusOK,
+		expectResp:   execResponse{Stdout: "hello\n", Duration: "1s"},
+	}, {
+		name:         "failed",
+		method:       http.MethodPost,
+		body:         `{"cmd":"ls missing"}`,
+		runner:       &FakeRunner{ExpectStderr: "no such file", ExpectExitCode: 2},
+		expectStatus: http.StatusOK,
+		expectResp:   execResponse{Stderr: "no such file", ExitCode: 2, Error: "exit status 2", Duration: "1s"},
+	}, {
+		name:         "start error",
+		method:       http.MethodPost,
+		body:         `{"cmd":"missing"}`,
+		runner:       &FakeRunner{ExpectStartError: errors.New("executable file not found")},
+		expectStatus: http.StatusOK,
+		expectResp:   execResponse{ExitCode: -1, Error: "executable file not found", Duration: "1s"},
+	}, {
+		name:         "unknown profile",
+		method:       http.MethodPost,
+		body:         `{"cmd":"echo hello","profile":"missing"}`,
+		runner:       &FakeRunner{},
+		expectStatus: http.StatusBadRequest,
+	}, {
+		name:         "invalid method",
+		method:       http.MethodGet,
+		runner:       &FakeRunner{},
+		expectStatus: http.StatusMethodNotAllowed,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, tt.runner)
+			recorder := httptest.NewRecorder()
+			server.handler.ServeHTTP(recorder, httptest.NewRequest(tt.method, "/api/exec", strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.expectStatus, recorder.Code, recorder.Body.String())
+			if tt.expectStatus != http.StatusOK {
+				assert.Empty(t, tt.runner.Commands())
+				return
+			}
+			var resp execResponse
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &resp))
+			assert.Equal(t, tt.expectResp, resp)
+			if assert.Len(t, tt.runner.Commands(), 1) {
+				assert.Equal(t, "sh", filepath.Base(tt.runner.Commands()[0][0]))
+			}
+		})
+	}
+}
+
+func TestHandleStream(t *testing.T) {
+	tests := []struct {
+		name         string
+		runner       *FakeRunner
+		expectEvents []string
+	}{{
+		name:   "succeeded",
+		runner: &FakeRunner{ExpectStdout: "one\ntwo\n"},
+		expectEvents: []string{
+			`{"type": "stdout", "data": "one"}`,
+			`{"type": "stdout", "data": "two"}`,
+			`{"type": "end", "exitCode": 0, "error": ""}`,
+		},
+	}, {
+		name:   "failed",
+		runner: &FakeRunner{ExpectStderr: "oops\n", ExpectExitCode: 3},
+		expectEvents: []string{
+			`{"type": "stderr", "data": "oops"}`,
+			`{"type": "end", "exitCode": 3, "error": "exit status 3"}`,
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, tt.runner)
+			recorder := httptest.NewRecorder()
+			body := strings.NewReader(`{"cmd":"run","terminalId":"` + tt.name + `"}`)
+			server.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/extensionProxy/terminal/exec", body))
+
+			assert.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+			var events []string
+			scanner := bufio.NewScanner(recorder.Body)
+			for scanner.Scan() {
+				if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+					events = append(events, data)
+				}
+			}
+			if assert.NotEmpty(t, events) {
+				assert.True(t, strings.HasPrefix(events[0], `{"type": "start", "pid": `), events[0])
+				assert.Equal(t, tt.expectEvents, events[1:])
+			}
+			assert.Len(t, tt.runner.Commands(), 1)
+		})
+	}
+}
+
+func TestJobs(t *testing.T) {
+	tests := []struct {
+		name           string
+		runner         *FakeRunner
+		expectStatus   string
+		expectExitCode int
+		expectLogs     string
+	}{{
+		name:         "succeeded",
+		runner:       &FakeRunner{ExpectStdout: "done\n"},
+		expectStatus: JobSucceeded,
+		expectLogs:   "done\n",
+	}, {
+		name:           "failed",
+		runner:         &FakeRunner{ExpectStdout: "out\n", ExpectStderr: "err\n", ExpectExitCode: 4},
+		expectStatus:   JobFailed,
+		expectExitCode: 4,
+		expectLogs:     "out\nerr\n",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, tt.runner)
+			recorder := httptest.NewRecorder()
+			server.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/jobs", strings.NewReader(`{"cmd":"build","labels":{"app":"demo"}}`)))
+			require.Equal(t, http.StatusAccepted, recorder.Code, recorder.Body.String())
+			var created Job
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &created))
+			assert.Equal(t, JobRunning, created.Status)
+
+			job, ok := server.jobs.get(created.ID)
+			require.True(t, ok)
+			select {
+			case <-job.done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("the job did not finish")
+			}
+
+			recorder = httptest.NewRecorder()
+			server.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/jobs/"+created.ID, nil))
+			require.Equal(t, http.StatusOK, recorder.Code)
+			var finished Job
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &finished))
+			assert.Equal(t, tt.expectStatus, finished.Status)
+			assert.Equal(t, tt.expectExitCode, finished.ExitCode)
+
+			recorder = httptest.NewRecorder()
+			server.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/jobs/"+created.ID+"/logs", nil))
+			assert.Equal(t, tt.expectLogs, recorder.Body.String())
+			assert.Equal(t, tt.expectStatus, recorder.Header().Get("X-Job-Status"))
+
+			recorder = httptest.NewRecorder()
+			server.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/jobs?label=app=demo", nil))
+			var jobs []Job
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &jobs))
+			assert.Len(t, jobs, 1)
+		})
+	}
+
+	t.Run("start error", func(t *testing.T) {
+		server := newTestServer(t, &FakeRunner{ExpectStartError: errors.New("no such command")})
+		recorder := httptest.NewRecorder()
+		server.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/jobs", strings.NewReader(`{"cmd":"build"}`)))
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "no such command")
+	})
+}
@@ -0,0 +1,445 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// the timeout of an expect step which has no timeout of its own
+const defaultExpectTimeout = 10 * time.Second
+
+// the tail of the unmatched output which is returned when a step fails
+const expectOutputTail = 2048
+
+// the unmatched output which is kept at most, the older output is dropped
+// so that a chatty session doesn't grow the buffer without bound
+const expectBufferLimit = 1 << 20
+
+// expectKeys are the key names which can be sent by an expect step
+var expectKeys = map[string]string{
+	"enter":     "\r",
+	"tab":       "\t",
+	"space":     " ",
+	"esc":       "\x1b",
+	"backspace": "\x7f",
+	"up":        "\x1b[A",
+	"down":      "\x1b[B",
+	"right":     "\x1b[C",
+	"left":      "\x1b[D",
+	"home":      "\x1b[H",
+	"end":       "\x1b[F",
+	"insert":    "\x1b[2~",
+	"delete":    "\x1b[3~",
+	"pageup":    "\x1b[5~",
+	"pagedown":  "\x1b[6~",
+}
+
+// ansiSequence matches the escape sequences which are removed from the
+// output before it's matched
+var ansiSequence = regexp.MustCompile(`\x1b(\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[PX^_][^\x1b]*\x1b\\|[ -/]*[0-~])`)
+
+// variableReference matches ${name} in the text which is sent
+var variableReference = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// ExpectRequest drives a new PTY session step by step
+type ExpectRequest struct {
+	Profile string `json:"profile,omitempty"`
+	// Host runs the session on a stored host instead of the profile, its key
+	// must be trusted already
+	Host    string `json:"host,omitempty"`
+	Seccomp string `json:"seccomp,omitempty"`
+	Cols    int    `json:"cols,omitempty"`
+	Rows    int    `json:"rows,omitempty"`
+	// Timeout is the default timeout of the expect steps
+	Timeout string       `json:"timeout,omitempty"`
+	Steps   []ExpectStep `json:"steps"`
+}
+
+// ExpectStep sends the text and the keys, and then waits for the output to
+// contain Expect or to match Regex. The groups of the regex are captured
+// into variables, by the names of the named groups or by Capture.
+type ExpectStep struct {
+	Send    string   `json:"send,omitempty"`
+	Keys    []string `json:"keys,omitempty"`
+	Expect  string   `json:"expect,omitempty"`
+	Regex   string   `json:"regex,omitempty"`
+	Capture []string `json:"capture,omitempty"`
+	Timeout string   `json:"timeout,omitempty"`
+}
+
+// ExpectResult is the result of all the steps, the screen is included when a step fails
+type ExpectResult struct {
+	Session   string             `json:"session"`
+	Success   bool               `json:"success"`
+	Steps     []ExpectStepResult `json:"steps"`
+	Variables map[string]string  `json:"variables"`
+	Duration  string             `json:"duration"`
+	Screen    string             `json:"screen,omitempty"`
+	Output    string             `json:"output,omitempty"`
+}
+
+// ExpectStepResult is the result of a single step
+type ExpectStepResult struct {
+	Index    int               `json:"index"`
+	Success  bool              `json:"success"`
+	Match    string            `json:"match,omitempty"`
+	Captures map[string]string `json:"captures,omitempty"`
+	Duration string            `json:"duration"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// expecter collects the output of a session for the expect steps
+type expecter struct {
+	session *Session
+	redact  func(string) string
+	buffer  strings.Builder
+	closed  bool
+	notify  chan struct{}
+	mutex   sync.Mutex
+}
+
+func newExpecter(session *Session, redact func(string) string) *expecter {
+	e := &expecter{
+		session: session,
+		redact:  redact,
+		notify:  make(chan struct{}, 1),
+	}
+	go e.read()
+	return e
+}
+
+func (e *expecter) read() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := e.session.shell.Read(buf)
+		if n > 0 {
+			data := []byte(e.redact(string(buf[:n])))
+			e.session.output(data)
+
+			e.mutex.Lock()
+			e.buffer.WriteString(data2text(data))
+			if e.buffer.Len() > expectBufferLimit {
+				output := e.buffer.String()
+				start := len(output) - expectBufferLimit
+				for start < len(output) && !utf8.RuneStart(output[start]) {
+					start++
+				}
+				e.buffer.Reset()
+				e.buffer.WriteString(output[start:])
+			}
+			e.mutex.Unlock()
+		}
+		if err != nil {
+			e.mutex.Lock()
+			e.closed = true
+			e.mutex.Unlock()
+		}
+		select {
+		case e.notify <- struct{}{}:
+		default:
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// data2text removes the escape sequences and the carriage returns
+func data2text(data []byte) string {
+	text := ansiSequence.ReplaceAllString(string(data), "")
+	return strings.ReplaceAll(text, "\r", "")
+}
+
+func (e *expecter) send(text string) (err error) {
+	e.session.input([]byte(text))
+	_, err = e.session.shell.Write([]byte(text))
+	return
+}
+
+// expect waits until the output matches, the output up to the end of the
+// match is consumed
+func (e *expecter) expect(pattern *regexp.Regexp, timeout time.Duration) (groups []string, err error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		e.mutex.Lock()
+		output, closed := e.buffer.String(), e.closed
+		if loc := pattern.FindStringSubmatchIndex(output); loc != nil {
+			for i := 0; i < len(loc); i += 2 {
+				if loc[i] >= 0 {
+					groups = append(groups, output[loc[i]:loc[i+1]])
+				} else {
+					groups = append(groups, "")
+				}
+			}
+			e.buffer.Reset()
+			e.buffer.WriteString(output[loc[1]:])
+			e.mutex.Unlock()
+			return
+		}
+		e.mutex.Unlock()
+
+		if closed {
+			return nil, errors.New("the session exited")
+		}
+		select {
+		case <-e.notify:
+		case <-timer.C:
+			return nil, fmt.Errorf("timed out after %s", timeout)
+		}
+	}
+}
+
+// tail returns the end of the output which is not matched yet
+func (e *expecter) tail() string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	output := e.buffer.String()
+	if len(output) > expectOutputTail {
+		output = output[len(output)-expectOutputTail:]
+	}
+	return output
+}
+
+// keySequence returns the bytes of a named key, ctrl+<letter> is supported as well
+func keySequence(name string) (string, error) {
+	name = strings.ToLower(name)
+	if seq, ok := expectKeys[name]; ok {
+		return seq, nil
+	}
+	if letter, ok := strings.CutPrefix(name, "ctrl+"); ok && len(letter) == 1 && letter[0] >= '@' && letter[0] <= '_'+0x20 {
+		return string(rune(letter[0] & 0x1f)), nil
+	}
+	return "", fmt.Errorf("unknown key: %q", name)
+}
+
+// expandVariables replaces ${name} with the captured variables, unknown
+// references are kept so that shell variables still work
+func expandVariables(text string, variables map[string]string) string {
+	return variableReference.ReplaceAllStringFunc(text, func(ref string) string {
+		if value, ok := variables[ref[2:len(ref)-1]]; ok {
+			return value
+		}
+		return ref
+	})
+}
+
+// compile returns the pattern of the step, or nil when it doesn't expect anything
+func (s ExpectStep) compile() (pattern *regexp.Regexp, err error) {
+	switch {
+	case s.Expect != "" && s.Regex != "":
+		err = errors.New("expect and regex can't be both set")
+	case s.Expect != "":
+		pattern = regexp.MustCompile(regexp.QuoteMeta(s.Expect))
+	case s.Regex != "":
+		pattern, err = regexp.Compile(s.Regex)
+	}
+	return
+}
+
+func parseTimeout(timeout string, defaultTimeout time.Duration) (time.Duration, error) {
+	if timeout == "" {
+		return defaultTimeout, nil
+	}
+	duration, err := time.ParseDuration(timeout)
+	if err == nil && duration <= 0 {
+		err = fmt.Errorf("invalid timeout: %s", timeout)
+	}
+	return duration, err
+}
+
+// validate checks the steps before a session is started
+func (r ExpectRequest) validate() (patterns []*regexp.Regexp, err error) {
+	if len(r.Steps) == 0 {
+		return nil, errors.New("no steps")
+	}
+	if _, err = parseTimeout(r.Timeout, defaultExpectTimeout); err != nil {
+		return
+	}
+	for i, step := range r.Steps {
+		var pattern *regexp.Regexp
+		if pattern, err = step.compile(); err == nil {
+			_, err = parseTimeout(step.Timeout, defaultExpectTimeout)
+		}
+		for _, key := range step.Keys {
+			if err == nil {
+				_, err = keySequence(key)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", i, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return
+}
+
+// run executes the steps until one of them fails
+func (e *expecter) run(req ExpectRequest, patterns []*regexp.Regexp) (result ExpectResult) {
+	begin := time.Now()
+	defaultTimeout, _ := parseTimeout(req.Timeout, defaultExpectTimeout)
+	result = ExpectResult{
+		Session:   e.session.ID,
+		Success:   true,
+		Steps:     []ExpectStepResult{},
+		Variables: map[string]string{},
+	}
+
+	for i, step := range req.Steps {
+		stepBegin := time.Now()
+		stepResult, err := e.runStep(step, patterns[i], defaultTimeout, result.Variables)
+		stepResult.Index = i
+		stepResult.Duration = time.Since(stepBegin).String()
+		if err != nil {
+			stepResult.Error = err.Error()
+			result.Success = false
+		}
+		result.Steps = append(result.Steps, stepResult)
+		if !result.Success {
+			result.Screen = e.session.screen.Text()
+			result.Output = e.tail()
+			break
+		}
+	}
+	result.Duration = time.Since(begin).String()
+	return
+}
+
+func (e *expecter) runStep(step ExpectStep, pattern *regexp.Regexp, defaultTimeout time.Duration,
+	variables map[string]string) (result ExpectStepResult, err error) {
+	if step.Send != "" {
+		if err = e.send(expandVariables(step.Send, variables)); err != nil {
+			return
+		}
+	}
+	for _, key := range step.Keys {
+		seq, _ := keySequence(key)
+		if err = e.send(seq); err != nil {
+			return
+		}
+	}
+
+	if pattern != nil {
+		timeout, _ := parseTimeout(step.Timeout, defaultTimeout)
+		var groups []string
+		if groups, err = e.expect(pattern, timeout); err != nil {
+			if step.Expect != "" {
+				err = fmt.Errorf("%q not found: %w", step.Expect, err)
+			} else {
+				err = fmt.Errorf("%q not matched: %w", step.Regex, err)
+			}
+			return
+		}
+		result.Match = groups[0]
+		result.Captures = captures(pattern, step.Capture, groups)
+		for name, value := range result.Captures {
+			variables[name] = value
+		}
+	}
+	result.Success = true
+	return
+}
+
+// captures names the groups by the named groups of the pattern, or by the
+// names of the step in order
+func captures(pattern *regexp.Regexp, names []string, groups []string) (values map[string]string) {
+	values = map[string]string{}
+	for i, name := range pattern.SubexpNames() {
+		if i > 0 && name != "" {
+			values[name] = groups[i]
+		}
+	}
+	for i, name := range names {
+		if i+1 < len(groups) && name != "" {
+			values[name] = groups[i+1]
+		}
+	}
+	if len(values) == 0 {
+		values = nil
+	}
+	return
+}
+
+// handleExpect runs the send and expect steps against a new PTY session
+func (s *ExecServer) handleExpect(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ExpectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	patterns, err := req.validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	profile, err := s.sessionProfile(req.Profile, req.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	release, ok := s.sessionLimiter.tryAcquire(requestUser(r))
+	if !ok {
+		loggerFrom(r.Context()).Warn("session rejected", "error", errSessionQuota)
+		http.Error(w, errSessionQuota.Error(), http.StatusTooManyRequests)
+		return
+	}
+	defer release()
+
+	done, err := s.startSession()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer done()
+
+	session, err := s.startPTYSession(r.Context(), "", profile, req.Seccomp)
+	if err != nil {
+		http.Error(w, err.Error(), hostKeyErrorStatus(err, http.StatusInternalServerError))
+		return
+	}
+	defer session.close()
+	defer session.shell.Close()
+	defer context.AfterFunc(s.ctx, func() { _ = session.shell.Close() })()
+
+	if req.Cols != 0 || req.Rows != 0 {
+		// the size which is not given is kept
+		if err := session.resize(cmp.Or(req.Cols, session.Cols), cmp.Or(req.Rows, session.Rows)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	_ = json.NewEncoder(w).Encode(newExpecter(session, s.redact).run(req, patterns))
+}
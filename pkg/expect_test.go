@@ -0,0 +1,55 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeShell is a PTY whose output is written by the test
+type pipeShell struct {
+	*io.PipeReader
+}
+
+func (p pipeShell) Write(data []byte) (int, error) { return len(data), nil }
+func (p pipeShell) Resize(int, int) error          { return nil }
+func (p pipeShell) Signal(string) error            { return nil }
+func (p pipeShell) Wait() error                    { return nil }
+func (p pipeShell) Pid() int                       { return 0 }
+
+func TestExpecterBufferLimit(t *testing.T) {
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	session := &Session{Type: SessionTypePTY, screen: NewScreen(defaultCols, defaultRows), shell: pipeShell{reader}, metrics: newMetrics()}
+	e := newExpecter(session, func(output string) string { return output })
+
+	_, err := writer.Write([]byte(strings.Repeat("x", expectBufferLimit+4096)))
+	require.NoError(t, err)
+	_, err = writer.Write([]byte("prompt$ "))
+	require.NoError(t, err)
+
+	groups, err := e.expect(regexp.MustCompile(`x+prompt\$ `), 5*time.Second)
+	require.NoError(t, err)
+	assert.Len(t, groups[0], expectBufferLimit)
+	assert.True(t, strings.HasSuffix(groups[0], "prompt$ "))
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/creack/pty"
+	fakeruntime "github.com/linuxsuren/go-fake-runtime"
+)
+
+// the environment variables which turn the test binary into a fake process
+const (
+	fakeProcessEnv  = "ATEST_TERMINAL_FAKE_PROCESS"
+	fakeOutputEnv   = "ATEST_TERMINAL_FAKE_OUTPUT"
+	fakeStderrEnv   = "ATEST_TERMINAL_FAKE_STDERR"
+	fakeExitCodeEnv = "ATEST_TERMINAL_FAKE_EXIT_CODE"
+	fakeEchoEnv     = "ATEST_TERMINAL_FAKE_ECHO"
+)
+
+// FakeRunner is for the unit test purposes. Instead of the command, it starts
+// the test binary again as a fake process, which prints the expected output
+// and exits with the expected code. The test binary calls RunFakeProcess in
+// TestMain to act as the fake process.
+//
+//	func TestMain(m *testing.M) {
+//		pkg.RunFakeProcess()
+//		os.Exit(m.Run())
+//	}
+type FakeRunner struct {
+	fakeruntime.FakeExecer
+	ExpectStdout     string
+	ExpectStderr     string
+	ExpectExitCode   int
+	ExpectStartError error
+	// Echo makes the fake process copy its stdin to stdout before it exits
+	Echo bool
+
+	commands [][]string
+	mutex    sync.Mutex
+}
+
+// Command creates the command like the local runner, it's replaced once it starts
+func (f *FakeRunner) Command(ctx context.Context, line string) *exec.Cmd {
+	return exec.CommandContext(ctx, "sh", "-c", line)
+}
+
+// Start records the command, then starts the fake process instead
+func (f *FakeRunner) Start(cmd *exec.Cmd) error {
+	if err := f.fake(cmd); err != nil {
+		return err
+	}
+	return cmd.Start()
+}
+
+// StartPTY records the command, then starts the fake process on a PTY instead
+func (f *FakeRunner) StartPTY(cmd *exec.Cmd) (*os.File, error) {
+	if err := f.fake(cmd); err != nil {
+		return nil, err
+	}
+	return pty.Start(cmd)
+}
+
+// Commands returns the arguments of the commands which were started
+func (f *FakeRunner) Commands() [][]string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([][]string{}, f.commands...)
+}
+
+func (f *FakeRunner) fake(cmd *exec.Cmd) (err error) {
+	f.mutex.Lock()
+	f.commands = append(f.commands, cmd.Args)
+	f.mutex.Unlock()
+	if f.ExpectStartError != nil {
+		return f.ExpectStartError
+	}
+
+	var self string
+	if self, err = os.Executable(); err != nil {
+		return
+	}
+	// the isolation, the seccomp wrapper and the cgroup are not faked
+	cmd.Path, cmd.Args, cmd.Err, cmd.SysProcAttr = self, []string{self}, nil, nil
+	cmd.Env = append(cmd.Env,
+		fakeProcessEnv+"=true",
+		fakeOutputEnv+"="+f.ExpectStdout,
+		fakeStderrEnv+"="+f.ExpectStderr,
+		fakeExitCodeEnv+"="+strconv.Itoa(f.ExpectExitCode),
+		fakeEchoEnv+"="+strconv.FormatBool(f.Echo))
+	return
+}
+
+// RunFakeProcess acts as the fake process of a FakeRunner, it does nothing
+// unless the binary was started by a FakeRunner
+func RunFakeProcess() {
+	if os.Getenv(fakeProcessEnv) == "" {
+		return
+	}
+	if strings.EqualFold(os.Getenv(fakeEchoEnv), "true") {
+		_, _ = io.Copy(os.Stdout, os.Stdin)
+	}
+	fmt.Fprint(os.Stdout, os.Getenv(fakeOutputEnv))
+	fmt.Fprint(os.Stderr, os.Getenv(fakeStderrEnv))
+	code, _ := strconv.Atoi(os.Getenv(fakeExitCodeEnv))
+	os.Exit(code)
+}
@@ -0,0 +1,678 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"gopkg.in/yaml.v3"
+)
+
+// the ways of authenticating to a stored host
+const (
+	HostAuthPassword = "password"
+	HostAuthKey      = "key"
+	HostAuthAgent    = "agent"
+)
+
+// how long the user is waited for to answer the prompt of an unknown host key
+const hostKeyPromptTimeout = time.Minute
+
+// HostsConfig decides where the SSH hosts are stored, the files are under the
+// user config directory by default
+type HostsConfig struct {
+	// File keeps the hosts, the credentials in it are encrypted
+	File string `yaml:"file"`
+	// KeyFile is the key of the credentials, it's generated if it does not exist
+	KeyFile string `yaml:"keyFile"`
+	// KnownHostsFile is managed by the server, the keys are trusted through
+	// the API or the prompt of the terminal
+	KnownHostsFile string `yaml:"knownHostsFile"`
+}
+
+// Host is a stored SSH host which the terminal sessions connect to
+type Host struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Host        string `json:"host" yaml:"host"`
+	// Port is 22 if it's zero
+	Port int    `json:"port,omitempty" yaml:"port,omitempty"`
+	User string `json:"user" yaml:"user"`
+	// Auth is one of password, key and agent
+	Auth string `json:"auth" yaml:"auth"`
+	// Jump is the name of the host which the connections go through
+	Jump string `json:"jump,omitempty" yaml:"jump,omitempty"`
+	// Dir is the working directory on the host, a leading ~ is the home directory
+	Dir string            `json:"dir,omitempty" yaml:"dir,omitempty"`
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+
+	// Credentials are accepted when the host is saved, they are never returned
+	Credentials *HostCredentials `json:"credentials,omitempty" yaml:"-"`
+	// HasCredentials tells whether the credentials are stored
+	HasCredentials bool `json:"hasCredentials" yaml:"-"`
+	// HostKeys are the fingerprints of the trusted keys of the host
+	HostKeys []string `json:"hostKeys,omitempty" yaml:"-"`
+}
+
+// HostCredentials are the secrets of a host, they are encrypted in the hosts file
+type HostCredentials struct {
+	Password string `json:"password,omitempty"`
+	// PrivateKey is the private key in the PEM format
+	PrivateKey string `json:"privateKey,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+// storedHost is a host with its encrypted credentials
+type storedHost struct {
+	Host   `yaml:",inline"`
+	Secret string `yaml:"secret,omitempty"`
+}
+
+type hostsFile struct {
+	Hosts []storedHost `yaml:"hosts"`
+}
+
+// hostStore keeps the hosts in a YAML file
+type hostStore struct {
+	file       string
+	box        *secretBox
+	knownHosts *knownHostsStore
+	hosts      map[string]*storedHost
+	mutex      sync.RWMutex
+}
+
+func newHostStore() *hostStore {
+	return &hostStore{
+		hosts: make(map[string]*storedHost),
+	}
+}
+
+// load reads the stored hosts, the files which are not set are under the
+// user config directory. The key file and the known hosts file are created
+// if they don't exist.
+func (s *hostStore) load(config HostsConfig) (err error) {
+	if config.File == "" || config.KeyFile == "" || config.KnownHostsFile == "" {
+		var dir string
+		if dir, err = os.UserConfigDir(); err != nil {
+			return
+		}
+		dir = filepath.Join(dir, "atest", "terminal")
+		config.File = cmp.Or(config.File, filepath.Join(dir, "hosts.yaml"))
+		config.KeyFile = cmp.Or(config.KeyFile, filepath.Join(dir, "hosts.key"))
+		config.KnownHostsFile = cmp.Or(config.KnownHostsFile, filepath.Join(dir, "known_hosts"))
+	}
+	for _, file := range []string{config.File, config.KeyFile, config.KnownHostsFile} {
+		if err = os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+			return
+		}
+	}
+
+	var box *secretBox
+	if box, err = loadSecretBox(config.KeyFile); err != nil {
+		return
+	}
+	if err = writeNewFile(config.KnownHostsFile, nil); errors.Is(err, os.ErrExist) {
+		err = nil
+	} else if err != nil {
+		return
+	}
+
+	var data hostsFile
+	if content, readErr := os.ReadFile(config.File); readErr == nil {
+		if err = yaml.Unmarshal(content, &data); err != nil {
+			return fmt.Errorf("failed to parse the hosts: %w", err)
+		}
+	} else if !errors.Is(readErr, os.ErrNotExist) {
+		return readErr
+	}
+
+	hosts := make(map[string]*storedHost, len(data.Hosts))
+	for i := range data.Hosts {
+		item := &data.Hosts[i]
+		if err = item.validate(); err != nil {
+			return fmt.Errorf("host %q: %w", item.Name, err)
+		}
+		if hosts[item.Name] != nil {
+			return fmt.Errorf("duplicated host %q", item.Name)
+		}
+		hosts[item.Name] = item
+	}
+	for _, item := range hosts {
+		if err = checkJump(hosts, item.Host); err != nil {
+			return
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.file, s.box = config.File, box
+	s.knownHosts = &knownHostsStore{file: config.KnownHostsFile}
+	s.hosts = hosts
+	return
+}
+
+func (h Host) validate() error {
+	if err := validateName("host", h.Name); err != nil {
+		return err
+	}
+	if h.Host == "" || h.User == "" {
+		return errors.New("host and user are required")
+	}
+	if h.Port < 0 || h.Port > 65535 {
+		return fmt.Errorf("invalid port %d", h.Port)
+	}
+	switch h.Auth {
+	case HostAuthPassword, HostAuthKey, HostAuthAgent:
+	default:
+		return fmt.Errorf("invalid auth %q, it's one of password, key and agent", h.Auth)
+	}
+	return nil
+}
+
+func (h Host) address() string {
+	return SSHConfig{Host: h.Host, Port: h.Port}.address()
+}
+
+// validate checks the credentials which the auth method needs
+func (c HostCredentials) validate(auth string) error {
+	switch auth {
+	case HostAuthPassword:
+		if c.Password == "" {
+			return errors.New("the password is required")
+		}
+	case HostAuthKey:
+		if c.PrivateKey == "" {
+			return errors.New("the private key is required")
+		}
+		if _, err := parsePrivateKey([]byte(c.PrivateKey), c.Passphrase); err != nil {
+			return fmt.Errorf("invalid private key: %w", err)
+		}
+	}
+	return nil
+}
+
+// only keeps the credentials which the auth method needs
+func (c HostCredentials) only(auth string) HostCredentials {
+	switch auth {
+	case HostAuthPassword:
+		return HostCredentials{Password: c.Password}
+	case HostAuthKey:
+		return HostCredentials{PrivateKey: c.PrivateKey, Passphrase: c.Passphrase}
+	}
+	return HostCredentials{}
+}
+
+// checkJump makes sure that the jump hosts of the host exist in the hosts
+// without a loop
+func checkJump(hosts map[string]*storedHost, host Host) error {
+	visited := map[string]bool{host.Name: true}
+	for jump := host.Jump; jump != ""; jump = hosts[jump].Jump {
+		if visited[jump] {
+			return fmt.Errorf("host %q: the jump hosts make a loop", host.Name)
+		}
+		visited[jump] = true
+		if hosts[jump] == nil {
+			return fmt.Errorf("host %q: jump host %q not found", host.Name, jump)
+		}
+	}
+	return nil
+}
+
+// put adds or replaces a host, the stored credentials are kept when the host
+// comes without them. The caller holds the lock.
+func (s *hostStore) put(host Host) (err error) {
+	if err = host.validate(); err != nil {
+		return
+	}
+	if err = checkJump(s.hosts, host); err != nil {
+		return
+	}
+
+	var credentials HostCredentials
+	if host.Credentials != nil {
+		credentials = *host.Credentials
+	} else if previous := s.hosts[host.Name]; previous != nil {
+		if err = s.box.open(host.Name, previous.Secret, &credentials); err != nil {
+			return
+		}
+	}
+	credentials = credentials.only(host.Auth)
+	if err = credentials.validate(host.Auth); err != nil {
+		return
+	}
+
+	item := &storedHost{Host: host}
+	item.Credentials, item.HasCredentials, item.HostKeys = nil, false, nil
+	if credentials != (HostCredentials{}) {
+		if item.Secret, err = s.box.seal(host.Name, credentials); err != nil {
+			return
+		}
+	}
+	s.hosts[host.Name] = item
+	return
+}
+
+// usedBy returns the hosts which go through the host, the caller holds the lock
+func (s *hostStore) usedBy(name string) (names []string) {
+	for _, item := range s.hosts {
+		if item.Jump == name {
+			names = append(names, item.Name)
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// save writes the hosts into the file, the caller holds the lock
+func (s *hostStore) save() error {
+	data := hostsFile{Hosts: []storedHost{}}
+	for _, item := range s.hosts {
+		data.Hosts = append(data.Hosts, *item)
+	}
+	sort.Slice(data.Hosts, func(i, j int) bool {
+		return data.Hosts[i].Name < data.Hosts[j].Name
+	})
+	content, err := yaml.Marshal(data)
+	if err == nil {
+		tmp := s.file + ".tmp"
+		if err = os.WriteFile(tmp, content, 0o600); err == nil {
+			err = os.Rename(tmp, s.file)
+		}
+	}
+	return err
+}
+
+// view is the host which is sent to the clients, the caller holds the lock
+func (s *hostStore) view(item *storedHost) Host {
+	host := item.Host
+	host.HasCredentials = item.Secret != ""
+	if known, err := s.knownHosts.callback(); err == nil {
+		for _, key := range knownKeys(known, host.address(), &net.TCPAddr{IP: net.IPv4zero}) {
+			host.HostKeys = append(host.HostKeys, ssh.FingerprintSHA256(key.Key))
+		}
+	}
+	return host
+}
+
+func (s *hostStore) list() []Host {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	hosts := make([]Host, 0, len(s.hosts))
+	for _, item := range s.hosts {
+		hosts = append(hosts, s.view(item))
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].Name < hosts[j].Name
+	})
+	return hosts
+}
+
+// profile returns the profile of the sessions on the host
+func (s *hostStore) profile(name string) (profile Profile, err error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	item := s.hosts[name]
+	if item == nil {
+		return profile, fmt.Errorf("host %q not found", name)
+	}
+
+	profile = Profile{Name: name, Description: item.Description, Dir: item.Dir, Env: item.Env}
+	profile.SSH, err = s.sshConfig(item)
+	return
+}
+
+// sshConfig decrypts the credentials of the host and its jump hosts, the
+// caller holds the lock
+func (s *hostStore) sshConfig(item *storedHost) (config *SSHConfig, err error) {
+	var credentials HostCredentials
+	if err = s.box.open(item.Name, item.Secret, &credentials); err != nil {
+		return
+	}
+	config = &SSHConfig{
+		Host:       item.Host.Host,
+		Port:       item.Port,
+		User:       item.User,
+		Password:   credentials.Password,
+		Passphrase: credentials.Passphrase,
+		Agent:      item.Auth == HostAuthAgent,
+		privateKey: []byte(credentials.PrivateKey),
+		knownHosts: s.knownHosts,
+	}
+	if jump := s.hosts[item.Jump]; jump != nil {
+		config.jump, err = s.sshConfig(jump)
+	}
+	return
+}
+
+// sessionProfile returns the profile of a session, it's the stored host if
+// the host is given
+func (s *ExecServer) sessionProfile(profile, host string) (Profile, error) {
+	if host != "" {
+		return s.hosts.profile(host)
+	}
+	return s.getProfile(profile)
+}
+
+// HostKeyError is returned when the key of a host is not trusted
+type HostKeyError struct {
+	Host        string `json:"host"`
+	Fingerprint string `json:"fingerprint"`
+	// Changed tells that the host has another known key
+	Changed bool `json:"changed"`
+}
+
+func (e *HostKeyError) Error() string {
+	if e.Changed {
+		return fmt.Sprintf("the host key of %s has changed to %s, it may be a man-in-the-middle attack", e.Host, e.Fingerprint)
+	}
+	return fmt.Sprintf("the host key of %s is not trusted, its fingerprint is %s", e.Host, e.Fingerprint)
+}
+
+// hostKeyErrorStatus returns 409 if the host key is not trusted, or the given status
+func hostKeyErrorStatus(err error, status int) int {
+	var hostKeyErr *HostKeyError
+	if errors.As(err, &hostKeyErr) {
+		return http.StatusConflict
+	}
+	return status
+}
+
+// trustPrompt asks whether an unknown host key is trusted
+type trustPrompt func(host string, key ssh.PublicKey) bool
+
+type trustPromptKey struct{}
+
+// withTrustPrompt makes the connections of the context ask about the unknown
+// host keys of the stored hosts
+func withTrustPrompt(ctx context.Context, prompt trustPrompt) context.Context {
+	return context.WithValue(ctx, trustPromptKey{}, prompt)
+}
+
+func trustPromptFrom(ctx context.Context) trustPrompt {
+	prompt, _ := ctx.Value(trustPromptKey{}).(trustPrompt)
+	return prompt
+}
+
+// knownHostsStore is the known hosts file which is managed by the server
+type knownHostsStore struct {
+	file  string
+	mutex sync.Mutex
+}
+
+// callback looks up the keys in the file as it's now
+func (k *knownHostsStore) callback() (ssh.HostKeyCallback, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return knownhosts.New(k.file)
+}
+
+// verify accepts the known keys, the prompt decides whether an unknown key
+// is trusted, and a changed key is always rejected
+func (k *knownHostsStore) verify(known ssh.HostKeyCallback, prompt trustPrompt) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := known(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		hostKeyErr := &HostKeyError{Host: hostname, Fingerprint: ssh.FingerprintSHA256(key), Changed: len(keyErr.Want) > 0}
+		if hostKeyErr.Changed || prompt == nil || !prompt(hostname, key) {
+			return hostKeyErr
+		}
+		return k.add(hostname, key)
+	}
+}
+
+// add trusts the key of the host
+func (k *knownHostsStore) add(address string, key ssh.PublicKey) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	file, err := os.OpenFile(k.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(file, knownhosts.Line([]string{knownhosts.Normalize(address)}, key))
+	return errors.Join(err, file.Close())
+}
+
+// remove forgets the keys of the host, the entries of the other hosts and
+// the markers are kept
+func (k *knownHostsStore) remove(address string) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	data, err := os.ReadFile(k.file)
+	if err != nil {
+		return err
+	}
+
+	host := knownhosts.Normalize(address)
+	var kept bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if fields := strings.Fields(line); len(fields) > 0 && !strings.HasPrefix(fields[0], "#") && !strings.HasPrefix(fields[0], "@") {
+			hosts := strings.Split(fields[0], ",")
+			remaining := hosts[:0]
+			for _, item := range hosts {
+				if item != host {
+					remaining = append(remaining, item)
+				}
+			}
+			if len(remaining) == 0 {
+				continue
+			}
+			line = strings.Join(append([]string{strings.Join(remaining, ",")}, fields[1:]...), " ")
+		}
+		kept.WriteString(line + "\n")
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	tmp := k.file + ".tmp"
+	if err = os.WriteFile(tmp, kept.Bytes(), 0o600); err == nil {
+		err = os.Rename(tmp, k.file)
+	}
+	return err
+}
+
+func (s *ExecServer) handleHosts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	switch r.Method {
+	case http.MethodGet:
+		_ = json.NewEncoder(w).Encode(s.hosts.list())
+	case http.MethodPost:
+		var host Host
+		if err := json.NewDecoder(r.Body).Decode(&host); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// the secrets of the new environment are masked once the lock is released
+		defer s.updateSecrets()
+		s.hosts.mutex.Lock()
+		defer s.hosts.mutex.Unlock()
+		if s.hosts.hosts[host.Name] != nil {
+			http.Error(w, fmt.Sprintf("host %q already exists", host.Name), http.StatusConflict)
+			return
+		}
+		if err := s.hosts.put(host); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.hosts.save(); err != nil {
+			delete(s.hosts.hosts, host.Name)
+			http.Error(w, "failed to save the hosts: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(s.hosts.view(s.hosts.hosts[host.Name]))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleHost returns, replaces or deletes a host
+func (s *ExecServer) handleHost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	name := r.PathValue("name")
+	defer s.updateSecrets()
+	s.hosts.mutex.Lock()
+	defer s.hosts.mutex.Unlock()
+	item := s.hosts.hosts[name]
+	if item == nil {
+		http.Error(w, "host not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		_ = json.NewEncoder(w).Encode(s.hosts.view(item))
+	case http.MethodPut:
+		var host Host
+		if err := json.NewDecoder(r.Body).Decode(&host); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		host.Name = name
+		if err := s.hosts.put(host); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.hosts.save(); err != nil {
+			s.hosts.hosts[name] = item
+			http.Error(w, "failed to save the hosts: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(s.hosts.view(s.hosts.hosts[name]))
+	case http.MethodDelete:
+		if names := s.hosts.usedBy(name); len(names) > 0 {
+			http.Error(w, fmt.Sprintf("host %q is the jump host of %s", name, strings.Join(names, ", ")), http.StatusConflict)
+			return
+		}
+		delete(s.hosts.hosts, name)
+		if err := s.hosts.save(); err != nil {
+			s.hosts.hosts[name] = item
+			http.Error(w, "failed to save the hosts: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// hostKeyStatus is the key which a host offers
+type hostKeyStatus struct {
+	Host        string `json:"host"`
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	// Trusted tells whether the key is in the known hosts
+	Trusted bool `json:"trusted"`
+	// Changed tells that the host has another known key
+	Changed bool `json:"changed"`
+}
+
+// handleHostKey manages the trusted key of a host. GET gets the key which the
+// host offers, POST trusts it if it has the given fingerprint, and DELETE
+// forgets the known keys once the key of the host is replaced.
+func (s *ExecServer) handleHostKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	profile, err := s.hosts.profile(r.PathValue("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	config := *profile.SSH
+	address := config.address()
+
+	switch r.Method {
+	case http.MethodGet, http.MethodPost:
+		var req struct {
+			Fingerprint string `json:"fingerprint"`
+		}
+		if r.Method == http.MethodPost {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Fingerprint == "" {
+				http.Error(w, "the fingerprint of the key is required", http.StatusBadRequest)
+				return
+			}
+		}
+
+		key, err := config.scanHostKey(r.Context())
+		if err != nil {
+			// the jump hosts must be trusted first
+			http.Error(w, err.Error(), hostKeyErrorStatus(err, http.StatusBadGateway))
+			return
+		}
+		known, err := config.knownHosts.callback()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		status := hostKeyStatus{Host: address, Type: key.Type(), Fingerprint: ssh.FingerprintSHA256(key)}
+		var keyErr *knownhosts.KeyError
+		if err = known(address, &net.TCPAddr{IP: net.IPv4zero}, key); err == nil {
+			status.Trusted = true
+		} else if errors.As(err, &keyErr) {
+			status.Changed = len(keyErr.Want) > 0
+		}
+
+		if r.Method == http.MethodPost && !status.Trusted {
+			if status.Changed || req.Fingerprint != status.Fingerprint {
+				w.WriteHeader(http.StatusConflict)
+				_ = json.NewEncoder(w).Encode(status)
+				return
+			}
+			if err = config.knownHosts.add(address, key); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			status.Trusted = true
+			loggerFrom(r.Context()).Info("host key trusted", "host", address, "fingerprint", status.Fingerprint)
+		}
+		_ = json.NewEncoder(w).Encode(status)
+	case http.MethodDelete:
+		if err := config.knownHosts.remove(address); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		loggerFrom(r.Context()).Info("host keys forgotten", "host", address)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
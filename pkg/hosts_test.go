@@ -0,0 +1,125 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestHostStoreLoad(t *testing.T) {
+	dir := t.TempDir()
+	config := HostsConfig{
+		File:           filepath.Join(dir, "hosts.yaml"),
+		KeyFile:        filepath.Join(dir, "hosts.key"),
+		KnownHostsFile: filepath.Join(dir, "known_hosts"),
+	}
+	require.NoError(t, os.WriteFile(config.File, []byte(`hosts:
+- {name: web, host: web.example.com, user: ci, auth: agent, jump: bastion}
+- {name: bastion, host: bastion.example.com, user: ci, auth: agent}
+`), 0o600))
+
+	store := newHostStore()
+	require.NoError(t, store.load(config))
+	assert.Len(t, store.hosts, 2)
+
+	// the files exist once it's loaded
+	other := newHostStore()
+	require.NoError(t, other.load(config))
+	assert.Len(t, other.hosts, 2)
+
+	// the current hosts are kept when the jump hosts make a loop
+	require.NoError(t, os.WriteFile(config.File, []byte(`hosts:
+- {name: web, host: web.example.com, user: ci, auth: agent, jump: bastion}
+- {name: bastion, host: bastion.example.com, user: ci, auth: agent, jump: web}
+`), 0o600))
+	loop := HostsConfig{File: config.File, KeyFile: filepath.Join(dir, "other.key"), KnownHostsFile: filepath.Join(dir, "other_hosts")}
+	assert.ErrorContains(t, store.load(loop), "loop")
+	assert.Equal(t, config.File, store.file)
+	assert.Equal(t, config.KnownHostsFile, store.knownHosts.file)
+	assert.Empty(t, store.hosts["bastion"].Jump)
+}
+
+func TestPromptHostKey(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := ssh.NewPublicKey(public)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		trust       bool
+		expectTrust bool
+	}{{
+		name:        "trusted",
+		trust:       true,
+		expectTrust: true,
+	}, {
+		name: "rejected",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trusted := make(chan bool, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+				if err != nil {
+					trusted <- false
+					return
+				}
+				defer conn.Close()
+				trusted <- promptHostKey(conn)("web.example.com:22", key)
+			}))
+			defer server.Close()
+
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+			require.NoError(t, err)
+			defer conn.Close()
+			// the keys in flight don't answer the prompt
+			require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("yes\r")))
+
+			var prompt hostKeyPrompt
+			for prompt.ID == "" {
+				messageType, msg, err := conn.ReadMessage()
+				require.NoError(t, err)
+				if messageType == websocket.BinaryMessage {
+					require.NoError(t, json.Unmarshal(msg, &prompt))
+				}
+			}
+			assert.Equal(t, hostKeyPrompt{Type: controlHostKey, ID: prompt.ID, Host: "web.example.com:22",
+				KeyType: key.Type(), Fingerprint: ssh.FingerprintSHA256(key)}, prompt)
+
+			// the reply to another prompt is ignored
+			for _, reply := range []hostKeyReply{{Type: controlHostKey, ID: "other", Trust: !tt.trust}, {Type: controlHostKey, ID: prompt.ID, Trust: tt.trust}} {
+				data, err := json.Marshal(reply)
+				require.NoError(t, err)
+				require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, data))
+			}
+			assert.Equal(t, tt.expectTrust, <-trusted)
+		})
+	}
+}
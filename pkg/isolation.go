@@ -0,0 +1,26 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+// Isolation describes the namespaces a command runs in. A non-nil Isolation
+// always puts the command into a fresh user and network namespace, where only
+// the loopback interface is available.
+type Isolation struct {
+	// Mount gives the command a private mount namespace
+	Mount bool `json:"mount,omitempty" yaml:"mount,omitempty"`
+	// PID gives the command a private PID namespace
+	PID bool `json:"pid,omitempty" yaml:"pid,omitempty"`
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// errUserNamespaceUnavailable is returned when the host does not allow
+// unprivileged users to create user namespaces
+var errUserNamespaceUnavailable = errors.New("unprivileged user namespaces are disabled on this host, network isolation is not available")
+
+// applyIsolation configures the command to start in new namespaces
+func applyIsolation(cmd *exec.Cmd, iso *Isolation) error {
+	if iso == nil {
+		return nil
+	}
+	if err := checkUserNamespaces(); err != nil {
+		return err
+	}
+
+	flags := syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET
+	if iso.Mount {
+		flags |= syscall.CLONE_NEWNS
+	}
+	if iso.PID {
+		flags |= syscall.CLONE_NEWPID
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= uintptr(flags)
+	// map the current user to root inside the namespace, so that the command
+	// keeps the capabilities to configure its own network namespace
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+
+	// a new network namespace starts with the loopback interface down, the
+	// helper brings it up and then replaces itself with the command
+	return wrapExecHelper(cmd, execHelperLoopback)
+}
+
+// setLoopbackUp brings up the loopback interface of the network namespace
+func setLoopbackUp() (err error) {
+	var fd int
+	if fd, err = unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0); err != nil {
+		return
+	}
+	defer unix.Close(fd)
+
+	var ifreq *unix.Ifreq
+	if ifreq, err = unix.NewIfreq("lo"); err != nil {
+		return
+	}
+	if err = unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifreq); err != nil {
+		return
+	}
+	ifreq.SetUint16(ifreq.Uint16() | unix.IFF_UP)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifreq)
+}
+
+// wrapIsolationError turns the low-level error of starting an isolated
+// command into a readable one
+func wrapIsolationError(iso *Isolation, err error) error {
+	if iso == nil || err == nil {
+		return err
+	}
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("%w: %v", errUserNamespaceUnavailable, err)
+	}
+	return err
+}
+
+// checkUserNamespaces checks the well-known sysctl knobs which disable
+// unprivileged user namespaces
+func checkUserNamespaces() error {
+	if os.Getuid() == 0 {
+		return nil
+	}
+
+	checks := map[string]string{
+		"/proc/sys/kernel/unprivileged_userns_clone":             "0",
+		"/proc/sys/user/max_user_namespaces":                     "0",
+		"/proc/sys/kernel/apparmor_restrict_unprivileged_userns": "1",
+	}
+	for file, disabled := range checks {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(data)) == disabled {
+			return fmt.Errorf("%w (%s is %s)", errUserNamespaceUnavailable, file, disabled)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,619 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Job statuses
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+	JobTimedOut  = "timedOut"
+)
+
+const (
+	jobMetaExt = ".json"
+	jobLogExt  = ".log"
+	// the size of a page of the logs unless the limit is given
+	jobLogPage = 64 * 1024
+	// how often the followers of the logs check for new output
+	jobLogPoll = 200 * time.Millisecond
+	// how long to wait for the output to be closed after a job exits
+	jobWaitDelay = 5 * time.Second
+)
+
+// JobConfig is the configuration of the background jobs
+type JobConfig struct {
+	Dir string `yaml:"dir"`
+	// Retention is how long a finished job is kept
+	Retention time.Duration `yaml:"retention"`
+	// MaxJobs is the number of finished jobs which are kept at most
+	MaxJobs int `yaml:"maxJobs"`
+	// Timeout is the timeout of a job which has no timeout of its own
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// JobRequest submits a command as a background job
+type JobRequest struct {
+	Cmd            string            `json:"cmd"`
+	Profile        string            `json:"profile,omitempty"`
+	Timeout        string            `json:"timeout,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Isolation      *Isolation        `json:"isolation,omitempty"`
+	SeccompProfile string            `json:"seccompProfile,omitempty"`
+}
+
+// Job is a command running in the background, its output is written to a
+// log file so that it doesn't depend on the client staying connected
+type Job struct {
+	ID       string            `json:"id"`
+	Cmd      string            `json:"cmd"`
+	Profile  string            `json:"profile"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Timeout  string            `json:"timeout"`
+	Status   string            `json:"status"`
+	Pid      int               `json:"pid,omitempty"`
+	ExitCode int               `json:"exitCode"`
+	Error    string            `json:"error,omitempty"`
+	Created  time.Time         `json:"created"`
+	Finished *time.Time        `json:"finished,omitempty"`
+	Duration string            `json:"duration,omitempty"`
+	LogSize  int64             `json:"logSize"`
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	mutex  sync.Mutex
+}
+
+// JobManager keeps the jobs and their logs in a directory
+type JobManager struct {
+	config JobConfig
+	jobs   map[string]*Job
+	log    *slog.Logger
+	// closing refuses the new jobs once the server is shutting down
+	closing bool
+	mutex   sync.RWMutex
+}
+
+// newJobManager creates the manager of the jobs in the directory, and loads
+// the jobs of the last run. The cache directory is used when the directory
+// is empty.
+func newJobManager(config JobConfig, log *slog.Logger) (m *JobManager, err error) {
+	if config.Dir == "" {
+		if config.Dir, err = os.UserCacheDir(); err != nil {
+			return
+		}
+		config.Dir = filepath.Join(config.Dir, "atest", "terminal", "jobs")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = time.Hour
+	}
+	if err = os.MkdirAll(config.Dir, 0o700); err != nil {
+		return
+	}
+
+	m = &JobManager{
+		config: config,
+		jobs:   make(map[string]*Job),
+		log:    log,
+	}
+	if err = m.load(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// setLimits changes the retention and the timeout of the jobs, the
+// directory is kept
+func (m *JobManager) setLimits(config JobConfig) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.config.Retention = config.Retention
+	m.config.MaxJobs = config.MaxJobs
+	if config.Timeout > 0 {
+		m.config.Timeout = config.Timeout
+	}
+}
+
+func (m *JobManager) path(id, ext string) string {
+	return filepath.Join(m.config.Dir, id+ext)
+}
+
+// load reads the jobs of the last run, the ones which were still running are
+// marked as failed since their processes are gone
+func (m *JobManager) load() (err error) {
+	var files []string
+	if files, err = filepath.Glob(filepath.Join(m.config.Dir, "*"+jobMetaExt)); err != nil {
+		return
+	}
+	for _, file := range files {
+		data, readErr := os.ReadFile(file)
+		if readErr != nil {
+			continue
+		}
+		job := &Job{}
+		if json.Unmarshal(data, job) != nil || job.ID == "" {
+			continue
+		}
+		job.done = make(chan struct{})
+		close(job.done)
+		if job.Status == JobRunning {
+			now := time.Now()
+			job.Status, job.Error, job.ExitCode, job.Finished = JobFailed, "interrupted by a restart", -1, &now
+			m.save(job)
+		}
+		m.mutex.Lock()
+		m.jobs[job.ID] = job
+		m.mutex.Unlock()
+	}
+	return
+}
+
+// save writes the metadata of the job, errors are ignored since the job
+// itself is still in the memory
+func (m *JobManager) save(job *Job) {
+	if data, err := json.Marshal(job); err == nil {
+		tmp := m.path(job.ID, jobMetaExt+".tmp")
+		if os.WriteFile(tmp, data, 0o600) == nil {
+			_ = os.Rename(tmp, m.path(job.ID, jobMetaExt))
+		}
+	}
+}
+
+// MarshalJSON encodes the job while holding its lock
+func (j *Job) MarshalJSON() ([]byte, error) {
+	type job Job
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return json.Marshal((*job)(j))
+}
+
+func (j *Job) finished() bool {
+	select {
+	case <-j.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (j *Job) status() string {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.Status
+}
+
+// submitJob starts the job in the background
+func (s *ExecServer) submitJob(req JobRequest) (job *Job, err error) {
+	if strings.TrimSpace(req.Cmd) == "" {
+		return nil, errors.New("no cmd")
+	}
+	m := s.jobs
+	profile, err := s.getProfile(req.Profile)
+	if err != nil {
+		return
+	}
+	m.mutex.RLock()
+	config, closing := m.config, m.closing
+	m.mutex.RUnlock()
+	if closing {
+		return nil, errShuttingDown
+	}
+	if config.Dir == "" {
+		return nil, errors.New("the job directory is not configured")
+	}
+	timeout, err := parseTimeout(req.Timeout, config.Timeout)
+	if err != nil {
+		return
+	}
+
+	execReq := execRequest{Cmd: req.Cmd, Profile: profile.Name, Isolation: req.Isolation, SeccompProfile: req.SeccompProfile}
+	execReq.withProfile(profile)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	cmd, _, err := prepareCommand(ctx, s.runner, s.policies.get(), execReq, profile)
+	if err != nil {
+		cancel()
+		return
+	}
+
+	job = &Job{
+		ID:      uuid.NewString(),
+		Cmd:     s.redact(req.Cmd),
+		Profile: profile.Name,
+		Labels:  req.Labels,
+		Timeout: timeout.String(),
+		Status:  JobRunning,
+		Created: time.Now(),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	logFile, err := os.OpenFile(m.path(job.ID, jobLogExt), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	output := &jobLog{job: job, file: logFile, redact: s.redact, metrics: s.metrics}
+	cmd.Stdout, cmd.Stderr = output, output
+	// don't wait for the children which keep the output open after the job is killed
+	cmd.WaitDelay = jobWaitDelay
+
+	if err = wrapIsolationError(execReq.Isolation, s.runner.Start(cmd)); err != nil {
+		cancel()
+		_ = logFile.Close()
+		_ = os.Remove(logFile.Name())
+		return nil, err
+	}
+	job.Pid = cmd.Process.Pid
+	finished := s.metrics.commandStarted(transportJob)
+	m.log.Info("job started", "job_id", job.ID, "pid", job.Pid, "cmd", job.Cmd)
+	m.mutex.Lock()
+	m.jobs[job.ID] = job
+	m.mutex.Unlock()
+	m.save(job)
+
+	go func() {
+		defer cancel()
+		err := s.checkSeccomp(execReq.SeccompProfile, cmd.Wait())
+		_ = logFile.Close()
+		m.finish(ctx, job, err)
+		finished(job.ExitCode)
+	}()
+	return
+}
+
+// finish records the result of the job
+func (m *JobManager) finish(ctx context.Context, job *Job, err error) {
+	job.mutex.Lock()
+	now := time.Now()
+	job.Finished = &now
+	job.Duration = now.Sub(job.Created).String()
+	switch {
+	case job.Status == JobCancelled:
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		job.Status = JobTimedOut
+	case err == nil:
+		job.Status = JobSucceeded
+	default:
+		job.Status = JobFailed
+	}
+	job.ExitCode = 0
+	if err != nil {
+		job.Error = err.Error()
+		job.ExitCode = -1
+		var exitErr interface{ ExitCode() int }
+		if errors.As(err, &exitErr) {
+			job.ExitCode = exitErr.ExitCode()
+		}
+	}
+	m.log.Info("job finished", "job_id", job.ID, "status", job.Status, "exit_code", job.ExitCode, "duration", job.Duration)
+	job.mutex.Unlock()
+	close(job.done)
+	m.save(job)
+}
+
+// cancelJob stops a running job
+func (m *JobManager) cancelJob(job *Job) error {
+	job.mutex.Lock()
+	if job.Status != JobRunning || job.cancel == nil {
+		job.mutex.Unlock()
+		return fmt.Errorf("job %s is %s", job.ID, job.Status)
+	}
+	job.Status = JobCancelled
+	job.mutex.Unlock()
+	job.cancel()
+	<-job.done
+	return nil
+}
+
+// DrainJobs refuses the new jobs, then waits for the running ones. The ones
+// which are still running once the context is done are cancelled.
+func (s *ExecServer) DrainJobs(ctx context.Context) (err error) {
+	return s.jobs.drain(ctx)
+}
+
+func (m *JobManager) drain(ctx context.Context) (err error) {
+	m.mutex.Lock()
+	m.closing = true
+	m.mutex.Unlock()
+
+	for _, job := range m.list(JobRunning, nil) {
+		select {
+		case <-job.done:
+		case <-ctx.Done():
+			m.log.Warn("cancelling the job which is still running", "job_id", job.ID)
+			if cancelErr := m.cancelJob(job); cancelErr == nil {
+				err = ctx.Err()
+			}
+		}
+	}
+	return
+}
+
+func (m *JobManager) get(id string) (job *Job, ok bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	job, ok = m.jobs[id]
+	return
+}
+
+// list returns the newest jobs first, filtered by the status and the labels
+func (m *JobManager) list(status string, labels map[string]string) (jobs []*Job) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	jobs = make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		if status != "" && job.status() != status {
+			continue
+		}
+		matched := true
+		for key, value := range labels {
+			if job.Labels[key] != value {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Created.After(jobs[j].Created)
+	})
+	return
+}
+
+// remove deletes a finished job and its log
+func (m *JobManager) remove(job *Job) error {
+	if !job.finished() {
+		return fmt.Errorf("job %s is still running", job.ID)
+	}
+	m.mutex.Lock()
+	delete(m.jobs, job.ID)
+	m.mutex.Unlock()
+	_ = os.Remove(m.path(job.ID, jobLogExt))
+	return os.Remove(m.path(job.ID, jobMetaExt))
+}
+
+// cleanup removes the finished jobs by the retention policy periodically,
+// until the context is done
+func (m *JobManager) cleanup(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		m.expire(time.Now())
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *JobManager) expire(now time.Time) {
+	m.mutex.RLock()
+	config := m.config
+	m.mutex.RUnlock()
+
+	var finished []*Job
+	for _, job := range m.list("", nil) {
+		// the runs of the schedules are kept by their history instead
+		if _, scheduled := job.Labels[scheduleLabel]; !scheduled && job.finished() {
+			finished = append(finished, job)
+		}
+	}
+	for i, job := range finished {
+		job.mutex.Lock()
+		expired := config.Retention > 0 && job.Finished != nil && now.Sub(*job.Finished) > config.Retention
+		job.mutex.Unlock()
+		if expired || (config.MaxJobs > 0 && i >= config.MaxJobs) {
+			_ = m.remove(job)
+		}
+	}
+}
+
+// jobLog writes the output of a job into its log file
+type jobLog struct {
+	job     *Job
+	file    *os.File
+	redact  func(string) string
+	metrics *metrics
+}
+
+func (l *jobLog) Write(data []byte) (n int, err error) {
+	// secrets split across two writes are not masked, it's best effort
+	if _, err = l.file.Write([]byte(l.redact(string(data)))); err == nil {
+		n = len(data)
+	}
+	l.metrics.countBytes(transportJob, directionOut, n)
+	if info, statErr := l.file.Stat(); statErr == nil {
+		l.job.mutex.Lock()
+		l.job.LogSize = info.Size()
+		l.job.mutex.Unlock()
+	}
+	return
+}
+
+// handleJobs lists the jobs, or submits a new one
+func (s *ExecServer) handleJobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		labels := map[string]string{}
+		for _, label := range query["label"] {
+			key, value, _ := strings.Cut(label, "=")
+			labels[key] = value
+		}
+		jobs := s.jobs.list(query.Get("status"), labels)
+		if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit >= 0 && limit < len(jobs) {
+			jobs = jobs[:limit]
+		}
+		_ = json.NewEncoder(w).Encode(jobs)
+	case http.MethodPost:
+		var req JobRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		job, err := s.submitJob(req)
+		if errors.Is(err, errShuttingDown) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(job)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleJob returns or deletes a job
+func (s *ExecServer) handleJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	job, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		_ = json.NewEncoder(w).Encode(job)
+	case http.MethodDelete:
+		if err := s.jobs.remove(job); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleCancelJob cancels a running job
+func (s *ExecServer) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	job, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	if err := s.jobs.cancelJob(job); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(job)
+}
+
+// handleJobLogs returns a page of the logs from the offset, the next offset
+// is in the X-Next-Offset header. With follow=true, the logs are streamed
+// until the job finishes.
+func (s *ExecServer) handleJobLogs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	job, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	offset, _ := strconv.ParseInt(query.Get("offset"), 10, 64)
+	limit, err := strconv.ParseInt(cmp.Or(query.Get("limit"), strconv.Itoa(jobLogPage)), 10, 64)
+	if err != nil || offset < 0 || limit <= 0 {
+		http.Error(w, "invalid offset or limit", http.StatusBadRequest)
+		return
+	}
+
+	file, err := os.Open(s.jobs.path(job.ID, jobLogExt))
+	if err != nil {
+		http.Error(w, "failed to open the logs: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if query.Get("follow") != "true" {
+		data, _ := io.ReadAll(io.LimitReader(file, limit))
+		w.Header().Set("X-Next-Offset", strconv.FormatInt(offset+int64(len(data)), 10))
+		w.Header().Set("X-Job-Status", job.status())
+		_, _ = w.Write(data)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	for {
+		// check before reading, so that the output written before the job
+		// finished is not missed
+		finished := job.finished()
+		if _, err := io.Copy(w, file); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if finished {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-job.done:
+		case <-time.After(jobLogPoll):
+		}
+	}
+}
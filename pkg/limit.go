@@ -0,0 +1,265 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// errQueueFull is returned when too many requests are waiting already
+var errQueueFull = errors.New("too many requests are waiting, try again later")
+
+// errSessionQuota is returned when an interactive session is over the limits
+var errSessionQuota = errors.New("too many terminal sessions are open, close one and try again")
+
+// LimitConfig are the limits of the concurrent commands and sessions, zero
+// means unlimited
+type LimitConfig struct {
+	Commands        int `json:"commands" yaml:"commands"`
+	CommandsPerUser int `json:"commandsPerUser" yaml:"commandsPerUser"`
+	Streams         int `json:"streams" yaml:"streams"`
+	StreamsPerUser  int `json:"streamsPerUser" yaml:"streamsPerUser"`
+	Sessions        int `json:"sessions" yaml:"sessions"`
+	SessionsPerUser int `json:"sessionsPerUser" yaml:"sessionsPerUser"`
+	// Queue is the number of the commands and streams which wait at most
+	Queue int `json:"queue" yaml:"queue"`
+}
+
+// validate checks that none of the limits is negative
+func (c LimitConfig) validate() error {
+	limits := map[string]int{
+		"commands": c.Commands, "commandsPerUser": c.CommandsPerUser,
+		"streams": c.Streams, "streamsPerUser": c.StreamsPerUser,
+		"sessions": c.Sessions, "sessionsPerUser": c.SessionsPerUser,
+		"queue": c.Queue,
+	}
+	for name, limit := range limits {
+		if limit < 0 {
+			return fmt.Errorf("%s must not be negative, got %d", name, limit)
+		}
+	}
+	return nil
+}
+
+// requestUser returns the user of the request, which is the user of its
+// token, or the client address when the tokens are not configured
+func requestUser(r *http.Request) string {
+	if user, ok := r.Context().Value(userKey{}).(string); ok {
+		return user
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// limiter bounds the concurrent holders globally and per user. The waiters
+// queue per user and the users take turns, so that a user who submits many
+// commands doesn't starve the others.
+type limiter struct {
+	name                    string
+	metrics                 *metrics
+	max, perUser, queueSize int
+
+	active  int
+	holders map[string]int
+	queues  map[string][]*waiter
+	// order is the rotation of the users who have waiters
+	order   []string
+	waiting int
+	mutex   sync.Mutex
+}
+
+type waiter struct {
+	user  string
+	ready chan struct{}
+	// position receives the latest position in the queue, counted from one
+	position chan int
+}
+
+func newLimiter(name string, metrics *metrics) *limiter {
+	return &limiter{
+		name:    name,
+		metrics: metrics,
+		holders: make(map[string]int),
+		queues:  make(map[string][]*waiter),
+	}
+}
+
+func (l *limiter) setLimits(max, perUser, queueSize int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.max, l.perUser, l.queueSize = max, perUser, queueSize
+	l.dispatch()
+}
+
+func (l *limiter) available(user string) bool {
+	return (l.max <= 0 || l.active < l.max) && (l.perUser <= 0 || l.holders[user] < l.perUser)
+}
+
+func (l *limiter) take(user string) {
+	l.active++
+	l.holders[user]++
+}
+
+func (l *limiter) release(user string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.active--
+	if l.holders[user]--; l.holders[user] <= 0 {
+		delete(l.holders, user)
+	}
+	l.dispatch()
+}
+
+// queueDepth is the number of the waiters
+func (l *limiter) queueDepth() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.waiting
+}
+
+// tryAcquire takes a slot without waiting, it's for the interactive sessions
+func (l *limiter) tryAcquire(user string) (release func(), ok bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.waiting > 0 || !l.available(user) {
+		l.metrics.policyDenied(l.name + "-limit")
+		return nil, false
+	}
+	l.take(user)
+	return sync.OnceFunc(func() { l.release(user) }), true
+}
+
+// acquire waits for a slot in the queue, the position is reported whenever
+// it changes until the slot is taken
+func (l *limiter) acquire(ctx context.Context, user string, onPosition func(int)) (release func(), err error) {
+	l.mutex.Lock()
+	if l.queueSize > 0 && l.waiting >= l.queueSize {
+		l.mutex.Unlock()
+		l.metrics.policyDenied(l.name + "-queue")
+		return nil, errQueueFull
+	}
+	w := &waiter{user: user, ready: make(chan struct{}), position: make(chan int, 1)}
+	if len(l.queues[user]) == 0 {
+		l.order = append(l.order, user)
+	}
+	l.queues[user] = append(l.queues[user], w)
+	l.waiting++
+	l.dispatch()
+	l.mutex.Unlock()
+
+	for {
+		select {
+		case <-w.ready:
+			return sync.OnceFunc(func() { l.release(user) }), nil
+		case position := <-w.position:
+			if onPosition != nil {
+				onPosition(position)
+			}
+		case <-ctx.Done():
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			select {
+			case <-w.ready:
+				// the slot was taken in the meantime
+				l.active--
+				if l.holders[user]--; l.holders[user] <= 0 {
+					delete(l.holders, user)
+				}
+			default:
+				l.dequeue(w)
+			}
+			l.dispatch()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// dequeue removes a waiter which gives up, the caller holds the lock
+func (l *limiter) dequeue(w *waiter) {
+	queue := l.queues[w.user]
+	for i, item := range queue {
+		if item == w {
+			l.queues[w.user] = append(queue[:i], queue[i+1:]...)
+			l.waiting--
+			break
+		}
+	}
+	if len(l.queues[w.user]) == 0 {
+		l.removeUser(w.user)
+	}
+}
+
+func (l *limiter) removeUser(user string) {
+	delete(l.queues, user)
+	for i, item := range l.order {
+		if item == user {
+			l.order = append(l.order[:i], l.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// dispatch hands the free slots to the waiters, one user after another, and
+// then tells the rest of them their positions. The caller holds the lock.
+func (l *limiter) dispatch() {
+	for granted := true; granted; {
+		granted = false
+		for i := 0; i < len(l.order); i++ {
+			user := l.order[i]
+			if !l.available(user) {
+				continue
+			}
+			w := l.queues[user][0]
+			l.queues[user] = l.queues[user][1:]
+			l.waiting--
+			l.take(user)
+			close(w.ready)
+			granted = true
+
+			// the user goes to the end of the rotation
+			l.order = append(l.order[:i], l.order[i+1:]...)
+			if len(l.queues[user]) > 0 {
+				l.order = append(l.order, user)
+			} else {
+				delete(l.queues, user)
+			}
+			break
+		}
+	}
+
+	// the positions follow the rotation: the first waiter of each user, then
+	// the second one, and so on
+	position := 0
+	for round := 0; position < l.waiting; round++ {
+		for _, user := range l.order {
+			if queue := l.queues[user]; round < len(queue) {
+				position++
+				select {
+				case <-queue[round].position:
+				default:
+				}
+				queue[round].position <- position
+			}
+		}
+	}
+}
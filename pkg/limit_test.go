@@ -0,0 +1,206 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterTryAcquire(t *testing.T) {
+	l := newLimiter("test", newMetrics())
+	l.setLimits(3, 2, 0)
+
+	releaseA1, ok := l.tryAcquire("a")
+	require.True(t, ok)
+	_, ok = l.tryAcquire("a")
+	require.True(t, ok)
+	_, ok = l.tryAcquire("a")
+	assert.False(t, ok, "over the limit per user")
+
+	_, ok = l.tryAcquire("b")
+	require.True(t, ok)
+	_, ok = l.tryAcquire("c")
+	assert.False(t, ok, "over the global limit")
+
+	// releasing twice frees a single slot
+	releaseA1()
+	releaseA1()
+	_, ok = l.tryAcquire("c")
+	assert.True(t, ok)
+	_, ok = l.tryAcquire("c")
+	assert.False(t, ok)
+}
+
+// grant is a slot taken by a waiter of the limiter
+type grant struct {
+	name    string
+	release func()
+}
+
+// enqueue starts waiting for a slot, and returns once the waiter is queued
+func enqueue(t *testing.T, l *limiter, ctx context.Context, name, user string, granted chan<- grant) <-chan error {
+	depth := l.queueDepth()
+	failed := make(chan error, 1)
+	go func() {
+		release, err := l.acquire(ctx, user, nil)
+		if err != nil {
+			failed <- err
+			return
+		}
+		granted <- grant{name: name, release: release}
+	}()
+	require.Eventually(t, func() bool { return l.queueDepth() == depth+1 }, time.Second, time.Millisecond)
+	return failed
+}
+
+func TestLimiterFairness(t *testing.T) {
+	l := newLimiter("test", newMetrics())
+	l.setLimits(1, 0, 0)
+	release, err := l.acquire(context.Background(), "x", nil)
+	require.NoError(t, err)
+
+	// a user who queues many commands doesn't hold up the others
+	granted := make(chan grant)
+	for _, name := range []string{"a1", "a2", "a3"} {
+		enqueue(t, l, context.Background(), name, "a", granted)
+	}
+	enqueue(t, l, context.Background(), "b1", "b", granted)
+
+	var order []string
+	release()
+	for range 4 {
+		g := <-granted
+		order = append(order, g.name)
+		g.release()
+	}
+	assert.Equal(t, []string{"a1", "b1", "a2", "a3"}, order)
+	assert.Equal(t, 0, l.queueDepth())
+}
+
+func TestLimiterPositions(t *testing.T) {
+	l := newLimiter("test", newMetrics())
+	l.setLimits(1, 0, 0)
+	release, err := l.acquire(context.Background(), "x", nil)
+	require.NoError(t, err)
+	granted := make(chan grant)
+	enqueue(t, l, context.Background(), "a1", "a", granted)
+
+	positions := make(chan int, 10)
+	go func() {
+		release, err := l.acquire(context.Background(), "b", func(position int) { positions <- position })
+		if err == nil {
+			granted <- grant{name: "b1", release: release}
+		}
+	}()
+	assert.Equal(t, 2, <-positions)
+
+	release()
+	g := <-granted
+	assert.Equal(t, "a1", g.name)
+	assert.Equal(t, 1, <-positions)
+	g.release()
+	assert.Equal(t, "b1", (<-granted).name)
+}
+
+func TestLimiterPerUserQueue(t *testing.T) {
+	l := newLimiter("test", newMetrics())
+	l.setLimits(0, 1, 0)
+	releaseA, err := l.acquire(context.Background(), "a", nil)
+	require.NoError(t, err)
+
+	// the other users are not held up by the queued command of a
+	granted := make(chan grant, 1)
+	enqueue(t, l, context.Background(), "a2", "a", granted)
+	releaseB, err := l.acquire(context.Background(), "b", nil)
+	require.NoError(t, err)
+	releaseB()
+
+	releaseA()
+	assert.Equal(t, "a2", (<-granted).name)
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := newLimiter("test", newMetrics())
+	l.setLimits(1, 0, 0)
+	release, err := l.acquire(context.Background(), "x", nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	granted := make(chan grant, 2)
+	failed := enqueue(t, l, ctx, "a1", "a", granted)
+	enqueue(t, l, context.Background(), "b1", "b", granted)
+
+	// the waiter which gives up leaves the queue, and the slot goes to the next one
+	cancel()
+	assert.ErrorIs(t, <-failed, context.Canceled)
+	assert.Equal(t, 1, l.queueDepth())
+	release()
+	g := <-granted
+	assert.Equal(t, "b1", g.name)
+	g.release()
+
+	// nothing is held by the cancelled waiter
+	_, ok := l.tryAcquire("a")
+	assert.True(t, ok)
+}
+
+func TestLimiterQueueFull(t *testing.T) {
+	l := newLimiter("test", newMetrics())
+	l.setLimits(1, 0, 1)
+	_, err := l.acquire(context.Background(), "x", nil)
+	require.NoError(t, err)
+	enqueue(t, l, context.Background(), "a1", "a", make(chan grant, 1))
+
+	_, err = l.acquire(context.Background(), "b", nil)
+	assert.ErrorIs(t, err, errQueueFull)
+}
+
+func TestHandleExecLimit(t *testing.T) {
+	server := newTestServer(t, &FakeRunner{})
+	server.commandLimiter.setLimits(1, 0, 1)
+	release, err := server.commandLimiter.acquire(context.Background(), "other", nil)
+	require.NoError(t, err)
+	defer release()
+
+	// the client gives up while waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	recorder := httptest.NewRecorder()
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		req := httptest.NewRequest(http.MethodPost, "/api/exec", strings.NewReader(`{"cmd": "true"}`)).WithContext(ctx)
+		server.handler.ServeHTTP(recorder, req)
+	}()
+	require.Eventually(t, func() bool { return server.commandLimiter.queueDepth() == 1 }, time.Second, time.Millisecond)
+
+	// the queue is full
+	full := httptest.NewRecorder()
+	server.handler.ServeHTTP(full, httptest.NewRequest(http.MethodPost, "/api/exec", strings.NewReader(`{"cmd": "true"}`)))
+	assert.Equal(t, http.StatusTooManyRequests, full.Code)
+
+	cancel()
+	<-finished
+	assert.NotEqual(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Empty(t, recorder.Body.String())
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// LogConfig is the config of the server logs
+type LogConfig struct {
+	// Level is one of debug, info, warn and error
+	Level string `json:"level" yaml:"level"`
+	// Format is text or json
+	Format string `json:"format" yaml:"format"`
+}
+
+type loggerKey struct{}
+
+func (c LogConfig) level() (level slog.Level, err error) {
+	if c.Level != "" {
+		if err = level.UnmarshalText([]byte(c.Level)); err != nil {
+			err = fmt.Errorf("invalid log level %q, expected debug, info, warn or error", c.Level)
+		}
+	}
+	return
+}
+
+func (c LogConfig) validate() (err error) {
+	if _, err = c.level(); err != nil {
+		return
+	}
+	switch strings.ToLower(c.Format) {
+	case "", "text", "json":
+	default:
+		err = fmt.Errorf("invalid log format %q, expected text or json", c.Format)
+	}
+	return
+}
+
+// newLogger returns a logger of the format, the level can be changed at runtime
+func newLogger(out io.Writer, format string, level slog.Leveler) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level}
+	if strings.ToLower(format) == "json" {
+		return slog.New(slog.NewJSONHandler(out, options))
+	}
+	return slog.New(slog.NewTextHandler(out, options))
+}
+
+// withLogger returns a context which carries the logger
+func withLogger(ctx context.Context, log *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, log)
+}
+
+// loggerFrom returns the logger of the context, or the default one
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if log, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return log
+	}
+	return slog.Default()
+}
+
+// withRequestLogger gives each request a logger derived from the base one with
+// the request ID and the user, the ID is sent back in the X-Request-Id header
+func withRequestLogger(base *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if id == "" {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-Id", id)
+
+		log := base.With("request_id", id, "user", requestUser(r))
+		log.Debug("request", "method", r.Method, "path", r.URL.Path)
+		next.ServeHTTP(w, r.WithContext(withLogger(r.Context(), log)))
+	})
+}
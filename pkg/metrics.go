@@ -0,0 +1,175 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// the namespace of all the metrics
+const metricsNamespace = "atest_terminal"
+
+// the transports of the commands in the metrics, the sessions use their
+// types instead
+const (
+	transportExec = "exec"
+	transportJob  = "job"
+)
+
+// the directions of the bytes in the metrics
+const (
+	directionIn  = "in"
+	directionOut = "out"
+)
+
+// metrics are the counters of a server, each server has its own ones so that
+// they're not mixed up when a process runs more than one
+type metrics struct {
+	commandsStarted           *prometheus.CounterVec
+	commandsFinished          *prometheus.CounterVec
+	commandDuration           *prometheus.HistogramVec
+	transferredBytes          *prometheus.CounterVec
+	websocketConnections      *prometheus.GaugeVec
+	websocketConnectionsTotal *prometheus.CounterVec
+	policyDenials             *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		commandsStarted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "commands_started_total",
+			Help:      "The number of the started commands.",
+		}, []string{"transport"}),
+		commandsFinished: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "commands_finished_total",
+			Help:      "The number of the finished commands by the exit code, -1 means it was not run or killed.",
+		}, []string{"transport", "exit_code"}),
+		commandDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "command_duration_seconds",
+			Help:      "The duration of the finished commands.",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 1800, 3600},
+		}, []string{"transport"}),
+		transferredBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "bytes_total",
+			Help:      "The bytes sent to (in) and received from (out) the commands and sessions.",
+		}, []string{"transport", "direction"}),
+		websocketConnections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "websocket_connections",
+			Help:      "The number of the open WebSocket connections.",
+		}, []string{"endpoint"}),
+		websocketConnectionsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "websocket_connections_total",
+			Help:      "The number of the accepted WebSocket connections.",
+		}, []string{"endpoint"}),
+		policyDenials: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "policy_denials_total",
+			Help:      "The number of the requests and commands denied by a policy, like seccomp or the concurrency limits.",
+		}, []string{"policy"}),
+	}
+}
+
+var (
+	activeSessionsDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "sessions_active"),
+		"The number of the active sessions.", []string{"type"}, nil)
+	queueDepthDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "queue_depth"),
+		"The number of the commands which wait for a free slot.", []string{"limiter"}, nil)
+)
+
+// newMetricsRegistry returns the registry of the metrics of the exec server,
+// the Go runtime and the process
+func newMetricsRegistry(server *ExecServer) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		stateCollector{server: server},
+		server.metrics.commandsStarted, server.metrics.commandsFinished, server.metrics.commandDuration,
+		server.metrics.transferredBytes, server.metrics.websocketConnections,
+		server.metrics.websocketConnectionsTotal, server.metrics.policyDenials,
+	)
+	return registry
+}
+
+// stateCollector reports the sessions and the queues of the server when they
+// are scraped
+type stateCollector struct {
+	server *ExecServer
+}
+
+func (stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeSessionsDesc
+	ch <- queueDepthDesc
+}
+
+func (c stateCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := map[string]int{SessionTypePTY: 0, SessionTypeStream: 0}
+	for _, session := range c.server.sessionManager.list() {
+		counts[session.Type]++
+	}
+	for sessionType, count := range counts {
+		ch <- prometheus.MustNewConstMetric(activeSessionsDesc, prometheus.GaugeValue, float64(count), sessionType)
+	}
+	for _, l := range []*limiter{c.server.commandLimiter, c.server.streamLimiter} {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(l.queueDepth()), l.name)
+	}
+}
+
+// commandStarted counts a started command, the returned function records
+// how it finished
+func (m *metrics) commandStarted(transport string) (finished func(exitCode int)) {
+	m.commandsStarted.WithLabelValues(transport).Inc()
+	begin := time.Now()
+	return func(exitCode int) {
+		m.commandsFinished.WithLabelValues(transport, strconv.Itoa(exitCode)).Inc()
+		m.commandDuration.WithLabelValues(transport).Observe(time.Since(begin).Seconds())
+	}
+}
+
+func (m *metrics) countBytes(transport, direction string, n int) {
+	if n > 0 {
+		m.transferredBytes.WithLabelValues(transport, direction).Add(float64(n))
+	}
+}
+
+// websocketConnected counts an open WebSocket connection, the returned
+// function is called once it's closed
+func (m *metrics) websocketConnected(endpoint string) (closed func()) {
+	m.websocketConnectionsTotal.WithLabelValues(endpoint).Inc()
+	m.websocketConnections.WithLabelValues(endpoint).Inc()
+	return func() { m.websocketConnections.WithLabelValues(endpoint).Dec() }
+}
+
+func (m *metrics) policyDenied(policy string) {
+	m.policyDenials.WithLabelValues(policy).Inc()
+}
+
+// metricsHandler serves the metrics in the Prometheus format
+func (s *ExecServer) metricsHandler() http.Handler {
+	return promhttp.HandlerFor(s.metricsRegistry, promhttp.HandlerOpts{})
+}
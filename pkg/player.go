@@ -0,0 +1,277 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// resetTerminal is the RIS sequence, it clears the screen before seeking
+const resetTerminal = "\x1bc"
+
+// castEvent is an event of an asciicast v2 recording
+type castEvent struct {
+	Time float64
+	Type string
+	Data string
+}
+
+// playerControl is sent by the client to control the playback
+type playerControl struct {
+	Type  string  `json:"type"`
+	Time  float64 `json:"time,omitempty"`
+	Speed float64 `json:"speed,omitempty"`
+}
+
+// Player control types
+const (
+	playerPause  = "pause"
+	playerResume = "resume"
+	playerToggle = "toggle"
+	playerSeek   = "seek"
+	playerSpeed  = "speed"
+)
+
+// player replays the output events of a recording to a WebSocket client
+type player struct {
+	conn      *websocket.Conn
+	events    []castEvent
+	speed     float64
+	idleLimit float64
+
+	// pos is the index of the next event, current is the time of the last one
+	pos     int
+	current float64
+	paused  bool
+}
+
+// readRecording parses an asciicast v2 file
+func readRecording(path string) (header recordingHeader, events []castEvent, err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		err = fmt.Errorf("empty recording %s", path)
+		return
+	}
+	if err = json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		err = fmt.Errorf("invalid recording header: %w", err)
+		return
+	}
+	if header.Version != 2 {
+		err = fmt.Errorf("unsupported asciicast version %d", header.Version)
+		return
+	}
+
+	for line := 2; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var raw []any
+		if err = json.Unmarshal(scanner.Bytes(), &raw); err != nil || len(raw) != 3 {
+			err = fmt.Errorf("invalid event at line %d of the recording", line)
+			return
+		}
+		t, okTime := raw[0].(float64)
+		eventType, okType := raw[1].(string)
+		data, okData := raw[2].(string)
+		if !okTime || !okType || !okData {
+			err = fmt.Errorf("invalid event at line %d of the recording", line)
+			return
+		}
+		events = append(events, castEvent{Time: t, Type: eventType, Data: data})
+	}
+	err = scanner.Err()
+	return
+}
+
+// run replays the events until the client disconnects, the connection is
+// kept after the last event so that the client can still seek back
+func (p *player) run(controls <-chan playerControl) {
+	var remaining time.Duration
+	for {
+		if p.paused || p.pos >= len(p.events) {
+			control, ok := <-controls
+			if !ok {
+				return
+			}
+			p.handle(control)
+			continue
+		}
+
+		if remaining <= 0 {
+			remaining = p.delay()
+		}
+		start := time.Now()
+		timer := time.NewTimer(remaining)
+		select {
+		case <-timer.C:
+			remaining = 0
+			if !p.send(p.events[p.pos]) {
+				return
+			}
+			p.current = p.events[p.pos].Time
+			p.pos++
+			if p.pos == len(p.events) {
+				_ = p.conn.WriteMessage(websocket.TextMessage, []byte("\r\n[end of recording]\r\n"))
+			}
+		case control, ok := <-controls:
+			timer.Stop()
+			if !ok {
+				return
+			}
+			// keep the rest of the delay when pausing, start over otherwise
+			remaining -= time.Since(start)
+			if control.Type != playerPause && control.Type != playerToggle {
+				remaining = 0
+			}
+			p.handle(control)
+		}
+	}
+}
+
+// delay is the real time to wait before the next event
+func (p *player) delay() time.Duration {
+	gap := p.events[p.pos].Time - p.current
+	if p.idleLimit > 0 && gap > p.idleLimit {
+		gap = p.idleLimit
+	}
+	if gap < 0 {
+		gap = 0
+	}
+	return time.Duration(gap / p.speed * float64(time.Second))
+}
+
+func (p *player) handle(control playerControl) {
+	switch control.Type {
+	case playerPause:
+		p.paused = true
+	case playerResume:
+		p.paused = false
+	case playerToggle:
+		p.paused = !p.paused
+	case playerSpeed:
+		if control.Speed > 0 {
+			p.speed = control.Speed
+		}
+	case playerSeek:
+		p.seek(control.Time)
+	}
+}
+
+// seek redraws the screen with all the output before the given time
+func (p *player) seek(t float64) {
+	var output strings.Builder
+	output.WriteString(resetTerminal)
+	p.pos = 0
+	for p.pos < len(p.events) && p.events[p.pos].Time <= t {
+		if p.events[p.pos].Type == "o" {
+			output.WriteString(p.events[p.pos].Data)
+		}
+		p.pos++
+	}
+	p.current = t
+	_ = p.conn.WriteMessage(websocket.TextMessage, []byte(output.String()))
+}
+
+func (p *player) send(event castEvent) bool {
+	if event.Type != "o" {
+		return true
+	}
+	return p.conn.WriteMessage(websocket.TextMessage, []byte(event.Data)) == nil
+}
+
+// handleReplay replays a recording over the same protocol as a live session.
+// The query parameters are speed, idleLimit (seconds) and seek (seconds). The
+// client controls the playback with JSON messages, for instance
+// {"type": "seek", "time": 10}, or toggles pause with the space key.
+func (s *ExecServer) handleReplay(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	path, err := recordingPath(s.policies.get().recording.Dir, query.Get("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	header, events, err := readRecording(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p := &player{
+		events:    events,
+		speed:     parseFloat(query.Get("speed"), 1),
+		idleLimit: parseFloat(query.Get("idleLimit"), header.IdleTimeLimit),
+	}
+	if p.speed <= 0 {
+		http.Error(w, "speed must be positive", http.StatusBadRequest)
+		return
+	}
+
+	if p.conn, err = s.upgrader.Upgrade(w, r, nil); err != nil {
+		loggerFrom(r.Context()).Warn("WebSocket upgrade failed", "error", err)
+		return
+	}
+	defer p.conn.Close()
+	defer s.metrics.websocketConnected("replay")()
+
+	if seek := parseFloat(query.Get("seek"), 0); seek > 0 {
+		p.seek(seek)
+	}
+
+	controls := make(chan playerControl)
+	go func() {
+		defer close(controls)
+		for {
+			_, msg, err := p.conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var control playerControl
+			if string(msg) == " " {
+				control.Type = playerToggle
+			} else if json.Unmarshal(msg, &control) != nil {
+				continue
+			}
+			controls <- control
+		}
+	}()
+	p.run(controls)
+	// drain the controls until the reader quits
+	for range controls {
+	}
+}
+
+func parseFloat(value string, defaultValue float64) float64 {
+	if result, err := strconv.ParseFloat(value, 64); err == nil {
+		return result
+	}
+	return defaultValue
+}
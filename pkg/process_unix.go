@@ -0,0 +1,64 @@
+//go:build !windows
+
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setProcessGroup starts the command in its own process group, which is
+// killed as a whole when the command is cancelled
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+}
+
+// signalForeground sends the signal to the foreground process group of the
+// terminal like the keys do, for instance, Ctrl+C. The process group of the
+// command gets it when the foreground one is unknown.
+func signalForeground(tty *os.File, cmd *exec.Cmd, sig syscall.Signal) error {
+	pgid := cmd.Process.Pid
+	// Fd() would make the file blocking, then closing it doesn't stop the reads
+	if conn, err := tty.SyscallConn(); err == nil {
+		_ = conn.Control(func(fd uintptr) {
+			if foreground, err := unix.IoctlGetInt(int(fd), unix.TIOCGPGRP); err == nil && foreground > 0 {
+				pgid = foreground
+			}
+		})
+	}
+	return syscall.Kill(-pgid, sig)
+}
+
+// killProcessGroup kills the process group which the command leads, only
+// the command itself is killed when it's not a group leader
+func killProcessGroup(cmd *exec.Cmd) error {
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
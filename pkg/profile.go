@@ -0,0 +1,254 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultProfile is the name of the built-in profile, which starts $SHELL
+// in the working directory of the extension
+const DefaultProfile = "default"
+
+// Profile is a named way of starting a terminal session
+type Profile struct {
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// Command is the program of the PTY sessions, $SHELL is used if it's empty
+	Command string   `json:"command,omitempty" yaml:"command,omitempty"`
+	Args    []string `json:"args,omitempty" yaml:"args,omitempty"`
+	// Dir is the working directory, a leading ~ is the home directory
+	Dir string            `json:"dir,omitempty" yaml:"dir,omitempty"`
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	// Login starts the command as a login shell by passing -l
+	Login     bool          `json:"login,omitempty" yaml:"login,omitempty"`
+	Limits    *CgroupLimits `json:"limits,omitempty" yaml:"limits,omitempty"`
+	Isolation *Isolation    `json:"isolation,omitempty" yaml:"isolation,omitempty"`
+	Seccomp   string        `json:"seccomp,omitempty" yaml:"seccomp,omitempty"`
+	// Record records the sessions in the asciicast format
+	Record bool `json:"record,omitempty" yaml:"record,omitempty"`
+	// SSH opens the terminal sessions on a remote host
+	SSH *SSHConfig `json:"ssh,omitempty" yaml:"ssh,omitempty"`
+}
+
+type profilesConfig struct {
+	Profiles []Profile `yaml:"profiles"`
+}
+
+// loadProfiles reads the session profiles from a YAML file
+func loadProfiles(file string, seccomp map[string]SeccompProfile) (items []Profile, err error) {
+	var data []byte
+	if data, err = os.ReadFile(file); err != nil {
+		return
+	}
+
+	config := profilesConfig{}
+	if err = yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid profiles file %s: %w", file, err)
+	}
+	if err = validateProfiles(config.Profiles, seccomp); err != nil {
+		return nil, fmt.Errorf("invalid profiles file %s: %w", file, err)
+	}
+	return config.Profiles, nil
+}
+
+// withDefaultProfile adds the built-in default profile, unless the items
+// define one with the same name
+func withDefaultProfile(items []Profile) []Profile {
+	if !hasProfile(items, DefaultProfile) {
+		items = append([]Profile{{Name: DefaultProfile, Description: "The default shell"}}, items...)
+	}
+	return items
+}
+
+func validateProfiles(items []Profile, seccomp map[string]SeccompProfile) error {
+	names := map[string]bool{}
+	for i, profile := range items {
+		if profile.Name == "" {
+			return fmt.Errorf("profiles[%d]: name is required", i)
+		}
+		if names[profile.Name] {
+			return fmt.Errorf("profiles[%d]: duplicated name %q", i, profile.Name)
+		}
+		names[profile.Name] = true
+
+		if _, ok := seccomp[profile.Seccomp]; profile.Seccomp != "" && !ok {
+			return fmt.Errorf("profiles[%d]: seccomp profile %q not found", i, profile.Seccomp)
+		}
+		if profile.Limits != nil {
+			if err := profile.Limits.validate(); err != nil {
+				return fmt.Errorf("profiles[%d].limits.%w", i, err)
+			}
+		}
+		if profile.SSH != nil {
+			if err := profile.SSH.validate(); err != nil {
+				return fmt.Errorf("profiles[%d]: %w", i, err)
+			}
+			if profile.Isolation != nil || profile.Seccomp != "" || profile.Limits != nil {
+				return fmt.Errorf("profiles[%d]: isolation, seccomp and limits don't apply to the remote hosts", i)
+			}
+		}
+	}
+	return nil
+}
+
+func hasProfile(items []Profile, name string) bool {
+	for _, item := range items {
+		if item.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// getProfile returns the profile by name, the default one if the name is empty
+func (s *ExecServer) getProfile(name string) (profile Profile, err error) {
+	if name == "" {
+		name = DefaultProfile
+	}
+
+	for _, item := range s.policies.get().profiles {
+		if item.Name == name {
+			return item, nil
+		}
+	}
+	err = fmt.Errorf("profile %q not found", name)
+	return
+}
+
+func (s *ExecServer) listProfiles() []Profile {
+	return append([]Profile{}, s.policies.get().profiles...)
+}
+
+// local fails for the profiles of the remote hosts, which only open terminal sessions
+func (p Profile) local() error {
+	if p.SSH != nil {
+		return fmt.Errorf("profile %q connects to a remote host, it only opens terminal sessions", p.Name)
+	}
+	return nil
+}
+
+// shellCommand creates the command of a PTY session
+func (p Profile) shellCommand(env EnvPolicy) (cmd *exec.Cmd, err error) {
+	command := p.Command
+	if command == "" {
+		command = defaultShell()
+	}
+
+	args := p.Args
+	if p.Login {
+		// -l is understood by sh, bash, zsh, ksh and fish. A leading dash in
+		// argv[0] would get lost once the command is wrapped for isolation.
+		args = append([]string{"-l"}, args...)
+	}
+	cmd = exec.Command(command, args...)
+	err = p.prepare(cmd, env)
+	return
+}
+
+// prepare sets the environment of the policy and the profile, and the
+// working directory of the command
+func (p Profile) prepare(cmd *exec.Cmd, policy EnvPolicy) (err error) {
+	env := policy.envMap()
+	for key, value := range p.Env {
+		env[key] = value
+	}
+	cmd.Env = make([]string, 0, len(env))
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	if p.Dir != "" {
+		cmd.Dir, err = expandHome(p.Dir)
+	}
+	return
+}
+
+// limits merges the limits of the profile into the global ones
+func (p Profile) limits(limits CgroupLimits) CgroupLimits {
+	if p.Limits != nil {
+		if p.Limits.MemoryMax != "" {
+			limits.MemoryMax = p.Limits.MemoryMax
+		}
+		if p.Limits.CPUMax != "" {
+			limits.CPUMax = p.Limits.CPUMax
+		}
+		if p.Limits.PidsMax != "" {
+			limits.PidsMax = p.Limits.PidsMax
+		}
+	}
+	return limits
+}
+
+// defaultShell returns $SHELL, or a reasonable one of the current OS
+func defaultShell() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	if runtime.GOOS == "windows" {
+		return "powershell.exe"
+	}
+	return "/bin/sh"
+}
+
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.New("cannot expand ~ without a home directory")
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~")), nil
+}
+
+// handleProfiles lists the profiles, which are the options of a new terminal
+func (s *ExecServer) handleProfiles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	policy := s.policies.get().env
+	items := s.listProfiles()
+	for i := range items {
+		if len(items[i].Env) == 0 {
+			continue
+		}
+		env := make(map[string]string, len(items[i].Env))
+		for key, value := range items[i].Env {
+			if policy.isSecret(key) {
+				value = secretMask
+			}
+			env[key] = value
+		}
+		items[i].Env = env
+	}
+	_ = json.NewEncoder(w).Encode(items)
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// recordingExt is the extension of the asciicast files
+const recordingExt = ".cast"
+
+// RecordingConfig decides where and how the sessions are recorded
+type RecordingConfig struct {
+	// Dir stores the recordings, recording is disabled if it's empty
+	Dir string `json:"dir" yaml:"dir"`
+	// Input records the input events as well, they might contain passwords
+	Input bool `json:"input" yaml:"input"`
+}
+
+// Recorder writes a terminal session in the asciicast v2 format, see
+// https://docs.asciinema.org/manual/asciicast/v2/
+type Recorder struct {
+	Name string
+
+	file    *os.File
+	encoder *json.Encoder
+	start   time.Time
+	input   bool
+	// pending keeps the incomplete UTF-8 sequence at the end of the last output
+	pending []byte
+	mutex   sync.Mutex
+}
+
+type recordingHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	// IdleTimeLimit is only used when playing, the recorder never sets it
+	IdleTimeLimit float64 `json:"idle_time_limit,omitempty"`
+}
+
+// newRecorder creates the recording file of a session in the directory
+func newRecorder(session *Session, dir string, cols, rows int, input bool) (recorder *Recorder, err error) {
+	if dir == "" {
+		err = errors.New("recording is disabled, the recording directory is not configured")
+		return
+	}
+
+	start := time.Now()
+	name := fmt.Sprintf("%s-%s%s", session.ID, start.Format("20060102-150405"), recordingExt)
+	var file *os.File
+	if file, err = os.OpenFile(filepath.Join(dir, filepath.Base(name)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640); err != nil {
+		return
+	}
+
+	recorder = &Recorder{
+		Name:    name,
+		file:    file,
+		encoder: json.NewEncoder(file),
+		start:   start,
+		input:   input,
+	}
+	recorder.encoder.SetEscapeHTML(false)
+	err = recorder.encoder.Encode(recordingHeader{
+		Version:   2,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: start.Unix(),
+		Title:     fmt.Sprintf("%s (%s)", session.ID, session.Profile),
+		Env:       map[string]string{"TERM": "xterm-256color"},
+	})
+	if err != nil {
+		_ = file.Close()
+	}
+	return
+}
+
+// Output records the output of the session
+func (r *Recorder) Output(data []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// the JSON strings must be valid UTF-8, keep a split character for later
+	data = append(r.pending, data...)
+	end := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				end = i
+			}
+			break
+		}
+	}
+	r.pending = append([]byte{}, data[end:]...)
+	if end > 0 {
+		r.write("o", string(data[:end]))
+	}
+}
+
+// Input records the input of the session if it's enabled
+func (r *Recorder) Input(data []byte) {
+	if !r.input {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.write("i", string(data))
+}
+
+// Resize records the new size of the terminal
+func (r *Recorder) Resize(cols, rows int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.write("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// Close flushes the pending output to the disk and closes the file
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.pending) > 0 {
+		r.write("o", string(r.pending))
+		r.pending = nil
+	}
+	syncErr := r.file.Sync()
+	return errors.Join(syncErr, r.file.Close())
+}
+
+func (r *Recorder) write(eventType, data string) {
+	elapsed := time.Since(r.start).Seconds()
+	// errors are ignored, a broken recording should not break the session
+	_ = r.encoder.Encode([]any{elapsed, eventType, data})
+}
+
+// RecordingInfo describes a stored recording
+type RecordingInfo struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+func listRecordings(dir string) (recordings []RecordingInfo, err error) {
+	recordings = []RecordingInfo{}
+	if dir == "" {
+		return
+	}
+
+	var entries []os.DirEntry
+	if entries, err = os.ReadDir(dir); err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), recordingExt) {
+			continue
+		}
+		info, infoErr := entry.Info()
+		if infoErr != nil {
+			continue
+		}
+		recordings = append(recordings, RecordingInfo{
+			Name:     entry.Name(),
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		})
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].Modified.After(recordings[j].Modified)
+	})
+	return
+}
+
+// recordingPath returns the path of a recording in the directory, the name
+// must not contain a path
+func recordingPath(dir, name string) (string, error) {
+	if dir == "" {
+		return "", errors.New("recording is disabled")
+	}
+	if name == "" || filepath.Base(name) != name || !strings.HasSuffix(name, recordingExt) {
+		return "", fmt.Errorf("invalid recording name %q", name)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+func (s *ExecServer) handleListRecordings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	recordings, err := listRecordings(s.policies.get().recording.Dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(recordings)
+}
+
+// handleRecording downloads or deletes a recording
+func (s *ExecServer) handleRecording(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	path, err := recordingPath(s.policies.get().recording.Dir, r.PathValue("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/x-asciicast")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(path)))
+		http.ServeFile(w, r, path)
+	case http.MethodDelete:
+		if err := os.Remove(path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				http.Error(w, "recording not found", http.StatusNotFound)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
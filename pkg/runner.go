@@ -0,0 +1,76 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/creack/pty"
+	fakeruntime "github.com/linuxsuren/go-fake-runtime"
+)
+
+// CommandRunner creates and starts the processes of the commands and the
+// sessions, so that the handlers don't depend on os/exec directly
+type CommandRunner interface {
+	// LookPath searches for an executable in PATH
+	LookPath(file string) (string, error)
+	// Command creates the command which runs a command line in the shell
+	Command(ctx context.Context, line string) *exec.Cmd
+	// Start starts the command without waiting for it
+	Start(cmd *exec.Cmd) error
+	// StartPTY starts the command on a new PTY, which is returned
+	StartPTY(cmd *exec.Cmd) (*os.File, error)
+}
+
+// LocalRunner runs the commands on this host
+type LocalRunner struct {
+	execer fakeruntime.Execer
+}
+
+// NewLocalRunner creates a runner of the local processes
+func NewLocalRunner() *LocalRunner {
+	return &LocalRunner{execer: fakeruntime.NewDefaultExecer()}
+}
+
+// LookPath searches for an executable in PATH
+func (r *LocalRunner) LookPath(file string) (string, error) {
+	return r.execer.LookPath(file)
+}
+
+// Command creates an exec.Command based on the operating system
+func (r *LocalRunner) Command(ctx context.Context, line string) (cmd *exec.Cmd) {
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd.exe", "/c", line)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", line)
+	}
+	// the minimal environment, the profile sets the one of the policy
+	cmd.Env = EnvPolicy{}.environ()
+	return
+}
+
+// Start starts the command without waiting for it
+func (r *LocalRunner) Start(cmd *exec.Cmd) error {
+	return cmd.Start()
+}
+
+// StartPTY starts the command on a new PTY
+func (r *LocalRunner) StartPTY(cmd *exec.Cmd) (*os.File, error) {
+	return pty.Start(cmd)
+}
@@ -0,0 +1,484 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Concurrency policies, they decide what happens when a schedule fires while
+// its last run is still running
+const (
+	ConcurrencySkip    = "skip"
+	ConcurrencyQueue   = "queue"
+	ConcurrencyReplace = "replace"
+)
+
+// the labels of the jobs started by a schedule
+const (
+	scheduleLabel = "schedule"
+	triggerLabel  = "trigger"
+)
+
+const defaultScheduleHistory = 10
+
+// Schedule runs a command as a background job by a cron expression
+type Schedule struct {
+	Name        string `json:"name" yaml:"name"`
+	Cron        string `json:"cron" yaml:"cron"`
+	Cmd         string `json:"cmd" yaml:"cmd"`
+	Profile     string `json:"profile,omitempty" yaml:"profile,omitempty"`
+	Timeout     string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	Concurrency string `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+	// History is the number of runs which are kept
+	History  int  `json:"history,omitempty" yaml:"history,omitempty"`
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+}
+
+// ScheduleStatus is a schedule with its next run and the kept runs, the
+// newest run first
+type ScheduleStatus struct {
+	Schedule
+	Next    *time.Time `json:"next,omitempty"`
+	Running bool       `json:"running"`
+	Queued  int        `json:"queued"`
+	Skipped int        `json:"skipped"`
+	Runs    []*Job     `json:"runs"`
+}
+
+type scheduleFile struct {
+	Schedules []Schedule `yaml:"schedules"`
+}
+
+// scheduled is a schedule which is waiting for its next run
+type scheduled struct {
+	Schedule
+	cron    CronSchedule
+	next    time.Time
+	active  *Job
+	queued  int
+	skipped int
+	stop    chan struct{}
+}
+
+// Scheduler runs the schedules, which are kept in a YAML file
+type Scheduler struct {
+	file      string
+	schedules map[string]*scheduled
+	jobs      *JobManager
+	// submit starts the job of a run
+	submit func(JobRequest) (*Job, error)
+	// getProfile checks the profile of a schedule
+	getProfile func(string) (Profile, error)
+	log        *slog.Logger
+	mutex      sync.Mutex
+}
+
+// newScheduler creates the scheduler of the jobs of a server
+func newScheduler(jobs *JobManager, submit func(JobRequest) (*Job, error), getProfile func(string) (Profile, error), log *slog.Logger) *Scheduler {
+	return &Scheduler{
+		schedules:  make(map[string]*scheduled),
+		jobs:       jobs,
+		submit:     submit,
+		getProfile: getProfile,
+		log:        log,
+	}
+}
+
+// load reads the schedules from the file and starts them, a file under the
+// user config directory is used when it is empty
+func (m *Scheduler) load(file string) (err error) {
+	if file == "" {
+		if file, err = os.UserConfigDir(); err != nil {
+			return
+		}
+		file = filepath.Join(file, "atest", "terminal", "schedules.yaml")
+	}
+	if err = os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return
+	}
+
+	var config scheduleFile
+	if data, readErr := os.ReadFile(file); readErr == nil {
+		if err = yaml.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("failed to parse the schedules: %w", err)
+		}
+	} else if !errors.Is(readErr, os.ErrNotExist) {
+		return readErr
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.file = file
+	for _, schedule := range config.Schedules {
+		if err = m.add(schedule); err != nil {
+			return fmt.Errorf("schedule %q: %w", schedule.Name, err)
+		}
+	}
+	return
+}
+
+func (s Schedule) validate() (err error) {
+	if err = validateName("schedule", s.Name); err != nil {
+		return
+	}
+	if s.Cmd == "" {
+		return fmt.Errorf("schedule %q has no cmd", s.Name)
+	}
+	if _, err = ParseCron(s.Cron); err != nil {
+		return
+	}
+	if _, err = parseTimeout(s.Timeout, time.Hour); err != nil {
+		return
+	}
+	switch s.Concurrency {
+	case "", ConcurrencySkip, ConcurrencyQueue, ConcurrencyReplace:
+	default:
+		return fmt.Errorf("unknown concurrency policy: %q", s.Concurrency)
+	}
+	if s.History < 0 {
+		return fmt.Errorf("invalid history: %d", s.History)
+	}
+	return
+}
+
+// validate checks the schedule and its profile
+func (m *Scheduler) validate(schedule Schedule) (err error) {
+	if err = schedule.validate(); err == nil {
+		_, err = m.getProfile(schedule.Profile)
+	}
+	return
+}
+
+// add starts a schedule, the caller holds the lock
+func (m *Scheduler) add(schedule Schedule) (err error) {
+	if err = m.validate(schedule); err != nil {
+		return
+	}
+	if _, ok := m.schedules[schedule.Name]; ok {
+		return fmt.Errorf("schedule %q already exists", schedule.Name)
+	}
+	if schedule.Concurrency == "" {
+		schedule.Concurrency = ConcurrencySkip
+	}
+	if schedule.History == 0 {
+		schedule.History = defaultScheduleHistory
+	}
+
+	item := &scheduled{Schedule: schedule, stop: make(chan struct{})}
+	item.cron, _ = ParseCron(schedule.Cron)
+	m.schedules[schedule.Name] = item
+	if !schedule.Disabled {
+		item.next = item.cron.Next(time.Now())
+		go m.loop(item)
+	}
+	return
+}
+
+// remove stops a schedule, the caller holds the lock
+func (m *Scheduler) remove(name string) (item *scheduled, ok bool) {
+	if item, ok = m.schedules[name]; ok {
+		close(item.stop)
+		delete(m.schedules, name)
+	}
+	return
+}
+
+// save writes the schedules into the file, the caller holds the lock
+func (m *Scheduler) save() error {
+	config := scheduleFile{Schedules: []Schedule{}}
+	for _, item := range m.schedules {
+		config.Schedules = append(config.Schedules, item.Schedule)
+	}
+	sort.Slice(config.Schedules, func(i, j int) bool {
+		return config.Schedules[i].Name < config.Schedules[j].Name
+	})
+	data, err := yaml.Marshal(config)
+	if err == nil {
+		tmp := m.file + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, m.file)
+		}
+	}
+	return err
+}
+
+// loop waits for the next time of the schedule until it's stopped
+func (m *Scheduler) loop(item *scheduled) {
+	for {
+		m.mutex.Lock()
+		next := item.next
+		m.mutex.Unlock()
+		if next.IsZero() {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-item.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			m.mutex.Lock()
+			item.next = item.cron.Next(time.Now())
+			m.mutex.Unlock()
+			if _, err := m.trigger(item, "cron"); err != nil {
+				m.log.Error("failed to run the schedule", "schedule", item.Name, "error", err)
+			}
+		}
+	}
+}
+
+// trigger starts a run by the concurrency policy, there might be no job
+// when it's skipped or queued, or when the schedule is removed while its
+// last run is being replaced
+func (m *Scheduler) trigger(item *scheduled, trigger string) (job *Job, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for item.active != nil && !item.active.finished() {
+		switch item.Concurrency {
+		case ConcurrencyQueue:
+			item.queued++
+			return
+		case ConcurrencyReplace:
+			// killing the run may take its WaitDelay, the lock is released
+			// meanwhile so that the other schedules are not held up
+			active := item.active
+			m.mutex.Unlock()
+			_ = m.jobs.cancelJob(active)
+			<-active.done
+			m.mutex.Lock()
+			if m.schedules[item.Name] != item {
+				// the schedule was removed or replaced in the meantime
+				return
+			}
+		default:
+			item.skipped++
+			return
+		}
+	}
+	return m.start(item, trigger)
+}
+
+// start submits the job of a run, the caller holds the lock
+func (m *Scheduler) start(item *scheduled, trigger string) (job *Job, err error) {
+	job, err = m.submit(JobRequest{
+		Cmd:     item.Cmd,
+		Profile: item.Profile,
+		Timeout: item.Timeout,
+		Labels:  map[string]string{scheduleLabel: item.Name, triggerLabel: trigger},
+	})
+	if err != nil {
+		return
+	}
+	item.active = job
+	go m.wait(item.Name, job)
+	return
+}
+
+// wait starts the queued run once the job finishes, and removes the runs
+// beyond the history. The schedule is looked up again since it might be
+// updated in the meantime.
+func (m *Scheduler) wait(name string, job *Job) {
+	<-job.done
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	item, ok := m.schedules[name]
+	if !ok {
+		return
+	}
+	m.prune(item.Name, item.History)
+	if item.active == job && item.queued > 0 {
+		item.queued--
+		if _, err := m.start(item, "queue"); err != nil {
+			m.log.Error("failed to run the schedule", "schedule", item.Name, "error", err)
+		}
+	}
+}
+
+// prune removes the finished runs beyond the history
+func (m *Scheduler) prune(name string, history int) {
+	kept := 0
+	for _, job := range m.jobs.list("", map[string]string{scheduleLabel: name}) {
+		if !job.finished() {
+			continue
+		}
+		if kept++; kept > history {
+			_ = m.jobs.remove(job)
+		}
+	}
+}
+
+func (m *Scheduler) status(item *scheduled) (status ScheduleStatus) {
+	status = ScheduleStatus{
+		Schedule: item.Schedule,
+		Running:  item.active != nil && !item.active.finished(),
+		Queued:   item.queued,
+		Skipped:  item.skipped,
+		Runs:     m.jobs.list("", map[string]string{scheduleLabel: item.Name}),
+	}
+	if !item.Disabled && !item.next.IsZero() {
+		next := item.next
+		status.Next = &next
+	}
+	return
+}
+
+func (m *Scheduler) list() (statuses []ScheduleStatus) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	statuses = make([]ScheduleStatus, 0, len(m.schedules))
+	for _, item := range m.schedules {
+		statuses = append(statuses, m.status(item))
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return
+}
+
+// handleSchedules lists the schedules, or creates a new one
+func (s *ExecServer) handleSchedules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	switch r.Method {
+	case http.MethodGet:
+		_ = json.NewEncoder(w).Encode(s.scheduler.list())
+	case http.MethodPost:
+		var schedule Schedule
+		if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.scheduler.mutex.Lock()
+		defer s.scheduler.mutex.Unlock()
+		if err := s.scheduler.add(schedule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.scheduler.save(); err != nil {
+			http.Error(w, "failed to save the schedules: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(s.scheduler.status(s.scheduler.schedules[schedule.Name]))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleSchedule returns, replaces or deletes a schedule
+func (s *ExecServer) handleSchedule(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	name := r.PathValue("name")
+	s.scheduler.mutex.Lock()
+	defer s.scheduler.mutex.Unlock()
+	item, ok := s.scheduler.schedules[name]
+	if !ok {
+		http.Error(w, "schedule not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		_ = json.NewEncoder(w).Encode(s.scheduler.status(item))
+	case http.MethodPut:
+		var schedule Schedule
+		if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		schedule.Name = name
+		if err := s.scheduler.validate(schedule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.scheduler.remove(name)
+		_ = s.scheduler.add(schedule)
+		// the running job is still tracked by the new schedule
+		updated := s.scheduler.schedules[name]
+		updated.active, updated.queued, updated.skipped = item.active, item.queued, item.skipped
+		if err := s.scheduler.save(); err != nil {
+			http.Error(w, "failed to save the schedules: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(s.scheduler.status(updated))
+	case http.MethodDelete:
+		s.scheduler.remove(name)
+		if err := s.scheduler.save(); err != nil {
+			http.Error(w, "failed to save the schedules: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.scheduler.prune(name, 0)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleRunSchedule runs a schedule now, by its concurrency policy
+func (s *ExecServer) handleRunSchedule(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.scheduler.mutex.Lock()
+	item, ok := s.scheduler.schedules[r.PathValue("name")]
+	s.scheduler.mutex.Unlock()
+	if !ok {
+		http.Error(w, "schedule not found", http.StatusNotFound)
+		return
+	}
+
+	job, err := s.scheduler.trigger(item, "manual")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		// skipped or queued since the last run is still running
+		s.scheduler.mutex.Lock()
+		defer s.scheduler.mutex.Unlock()
+		if item.Concurrency == ConcurrencyQueue {
+			w.WriteHeader(http.StatusAccepted)
+		} else {
+			w.WriteHeader(http.StatusConflict)
+		}
+		_ = json.NewEncoder(w).Encode(s.scheduler.status(item))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"log/slog"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeJobs submits the jobs of a scheduler without running anything, a job
+// runs until it's finished by the test or cancelled
+type fakeJobs struct {
+	// cancelDelay is how long a cancelled job takes to exit
+	cancelDelay time.Duration
+	jobs        []*Job
+	finish      []func()
+	mutex       sync.Mutex
+}
+
+func (f *fakeJobs) submit(req JobRequest) (*Job, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	job := &Job{ID: strconv.Itoa(len(f.jobs)), Cmd: req.Cmd, Labels: req.Labels, Status: JobRunning, done: make(chan struct{})}
+	finish := sync.OnceFunc(func() { close(job.done) })
+	job.cancel = func() { time.AfterFunc(f.cancelDelay, finish) }
+	f.jobs = append(f.jobs, job)
+	f.finish = append(f.finish, finish)
+	return job, nil
+}
+
+func (f *fakeJobs) submitted() []*Job {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]*Job{}, f.jobs...)
+}
+
+func newTestScheduler(t *testing.T, jobs *fakeJobs, schedules ...Schedule) *Scheduler {
+	manager, err := newJobManager(JobConfig{Dir: t.TempDir()}, slog.Default())
+	require.NoError(t, err)
+	scheduler := newScheduler(manager, jobs.submit, func(string) (Profile, error) { return Profile{}, nil }, slog.Default())
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	for _, schedule := range schedules {
+		// the tests trigger the runs themselves
+		schedule.Disabled = true
+		require.NoError(t, scheduler.add(schedule))
+	}
+	return scheduler
+}
+
+func TestSchedulerTrigger(t *testing.T) {
+	tests := []struct {
+		concurrency   string
+		expectJob     bool
+		expectQueued  int
+		expectSkipped int
+	}{{
+		concurrency:   ConcurrencySkip,
+		expectSkipped: 1,
+	}, {
+		concurrency:  ConcurrencyQueue,
+		expectQueued: 1,
+	}, {
+		concurrency: ConcurrencyReplace,
+		expectJob:   true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.concurrency, func(t *testing.T) {
+			jobs := &fakeJobs{}
+			scheduler := newTestScheduler(t, jobs, Schedule{Name: "backup", Cron: "@daily", Cmd: "backup", Concurrency: tt.concurrency})
+			item := scheduler.schedules["backup"]
+
+			first, err := scheduler.trigger(item, "manual")
+			require.NoError(t, err)
+			require.NotNil(t, first)
+			assert.Equal(t, map[string]string{scheduleLabel: "backup", triggerLabel: "manual"}, first.Labels)
+
+			second, err := scheduler.trigger(item, "cron")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectJob, second != nil)
+			status := scheduler.list()[0]
+			assert.Equal(t, tt.expectQueued, status.Queued)
+			assert.Equal(t, tt.expectSkipped, status.Skipped)
+			if tt.concurrency == ConcurrencyReplace {
+				assert.Equal(t, JobCancelled, first.Status)
+				assert.True(t, first.finished())
+			}
+
+			// the queued run starts once the last one finishes
+			jobs.finish[0]()
+			if tt.concurrency == ConcurrencyQueue {
+				assert.Eventually(t, func() bool { return len(jobs.submitted()) == 2 }, time.Second, 10*time.Millisecond)
+				assert.Equal(t, "queue", jobs.submitted()[1].Labels[triggerLabel])
+			}
+		})
+	}
+}
+
+func TestSchedulerReplaceDoesNotBlock(t *testing.T) {
+	jobs := &fakeJobs{cancelDelay: 500 * time.Millisecond}
+	scheduler := newTestScheduler(t, jobs,
+		Schedule{Name: "slow", Cron: "@daily", Cmd: "slow", Concurrency: ConcurrencyReplace},
+		Schedule{Name: "other", Cron: "@daily", Cmd: "other"})
+	slow, other := scheduler.schedules["slow"], scheduler.schedules["other"]
+
+	first, err := scheduler.trigger(slow, "manual")
+	require.NoError(t, err)
+	replaced := make(chan *Job)
+	go func() {
+		job, _ := scheduler.trigger(slow, "manual")
+		replaced <- job
+	}()
+	assert.Eventually(t, func() bool {
+		first.mutex.Lock()
+		defer first.mutex.Unlock()
+		return first.Status == JobCancelled
+	}, time.Second, 10*time.Millisecond)
+
+	// the other schedules run while the replaced run is exiting
+	begin := time.Now()
+	job, err := scheduler.trigger(other, "manual")
+	require.NoError(t, err)
+	assert.NotNil(t, job)
+	assert.Less(t, time.Since(begin), 250*time.Millisecond)
+	assert.False(t, first.finished())
+
+	job = <-replaced
+	require.NotNil(t, job)
+	assert.True(t, first.finished())
+	assert.Equal(t, "slow", job.Cmd)
+}
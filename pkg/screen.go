@@ -0,0 +1,796 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// Color is a terminal color: the default one, a palette index or true color
+type Color uint32
+
+const (
+	colorDefault Color = 0
+	colorPalette Color = 1 << 24
+	colorRGB     Color = 2 << 24
+)
+
+func paletteColor(index int) Color {
+	return colorPalette | Color(index&0xff)
+}
+
+func rgbColor(r, g, b int) Color {
+	return colorRGB | Color((r&0xff)<<16|(g&0xff)<<8|b&0xff)
+}
+
+// String returns an empty string for the default color, the index for a
+// palette color and #rrggbb for a true color
+func (c Color) String() string {
+	switch c & 0xff000000 {
+	case colorPalette:
+		return strconv.Itoa(int(c & 0xff))
+	case colorRGB:
+		return fmt.Sprintf("#%06x", uint32(c&0xffffff))
+	default:
+		return ""
+	}
+}
+
+// MarshalText encodes the color as its string form
+func (c Color) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// Style is the graphic rendition of a cell
+type Style struct {
+	FG        Color `json:"fg,omitempty"`
+	BG        Color `json:"bg,omitempty"`
+	Bold      bool  `json:"bold,omitempty"`
+	Dim       bool  `json:"dim,omitempty"`
+	Italic    bool  `json:"italic,omitempty"`
+	Underline bool  `json:"underline,omitempty"`
+	Inverse   bool  `json:"inverse,omitempty"`
+	Strike    bool  `json:"strike,omitempty"`
+}
+
+// sgr returns the SGR sequence which sets this style from the default one
+func (s Style) sgr() string {
+	params := []string{"0"}
+	for _, attr := range []struct {
+		on    bool
+		param string
+	}{{s.Bold, "1"}, {s.Dim, "2"}, {s.Italic, "3"}, {s.Underline, "4"}, {s.Inverse, "7"}, {s.Strike, "9"}} {
+		if attr.on {
+			params = append(params, attr.param)
+		}
+	}
+	params = append(params, colorSGR(s.FG, 38)...)
+	params = append(params, colorSGR(s.BG, 48)...)
+	return "\x1b[" + strings.Join(params, ";") + "m"
+}
+
+func colorSGR(c Color, base int) []string {
+	switch c & 0xff000000 {
+	case colorPalette:
+		return []string{strconv.Itoa(base), "5", strconv.Itoa(int(c & 0xff))}
+	case colorRGB:
+		return []string{strconv.Itoa(base), "2", strconv.Itoa(int(c >> 16 & 0xff)),
+			strconv.Itoa(int(c >> 8 & 0xff)), strconv.Itoa(int(c & 0xff))}
+	default:
+		return nil
+	}
+}
+
+// Cell is a single character cell of the screen. The second cell of a wide
+// character has an empty Char.
+type Cell struct {
+	Char string `json:"ch"`
+	Style
+}
+
+var blankCell = Cell{Char: " "}
+
+// parser states
+const (
+	stateGround = iota
+	stateEscape
+	stateEscapeCharset
+	stateCSI
+	stateOSC
+	stateOSCEscape
+	stateString
+	stateStringEscape
+)
+
+// Screen is a VT100/xterm emulator which keeps the visible screen of a session
+type Screen struct {
+	cols, rows int
+	main, alt  [][]Cell
+	// grid is either the main or the alternate screen
+	grid      [][]Cell
+	alternate bool
+
+	cursorX, cursorY int
+	cursorVisible    bool
+	// wrapPending is set after writing to the last column, the next
+	// character wraps to a new line
+	wrapPending bool
+	autowrap    bool
+	style       Style
+	title       string
+
+	scrollTop, scrollBottom int
+	saved                   savedCursor
+
+	state   int
+	params  []byte
+	osc     []byte
+	partial []byte
+	mutex   sync.Mutex
+}
+
+type savedCursor struct {
+	x, y  int
+	style Style
+}
+
+// NewScreen creates a screen with the given size
+func NewScreen(cols, rows int) *Screen {
+	s := &Screen{}
+	s.reset(cols, rows)
+	return s
+}
+
+// reset clears the state field by field, the lock is held by Write when a
+// reset sequence is received
+func (s *Screen) reset(cols, rows int) {
+	s.cols, s.rows = cols, rows
+	s.main, s.alt = newGrid(cols, rows), newGrid(cols, rows)
+	s.grid, s.alternate = s.main, false
+	s.cursorX, s.cursorY, s.cursorVisible = 0, 0, true
+	s.wrapPending, s.autowrap = false, true
+	s.style, s.title = Style{}, ""
+	s.scrollTop, s.scrollBottom = 0, rows-1
+	s.saved = savedCursor{}
+	s.state, s.params, s.osc = stateGround, nil, nil
+}
+
+func newGrid(cols, rows int) [][]Cell {
+	grid := make([][]Cell, rows)
+	for i := range grid {
+		grid[i] = newLine(cols)
+	}
+	return grid
+}
+
+func newLine(cols int) []Cell {
+	line := make([]Cell, cols)
+	for i := range line {
+		line[i] = blankCell
+	}
+	return line
+}
+
+// Write feeds the output of the session to the emulator
+func (s *Screen) Write(data []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	n := len(data)
+	if len(s.partial) > 0 {
+		data = append(s.partial, data...)
+		s.partial = nil
+	}
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 && !utf8.FullRune(data) {
+			// keep the split character until the next write
+			s.partial = append([]byte{}, data...)
+			break
+		}
+		data = data[size:]
+		s.handle(r)
+	}
+	return n, nil
+}
+
+func (s *Screen) handle(r rune) {
+	switch s.state {
+	case stateGround:
+		s.ground(r)
+	case stateEscape:
+		s.escape(r)
+	case stateEscapeCharset:
+		// the character set designation is not emulated
+		s.state = stateGround
+	case stateCSI:
+		if r >= 0x40 && r <= 0x7e {
+			s.state = stateGround
+			s.csi(r)
+		} else if r == 0x1b {
+			s.state = stateEscape
+		} else if r >= 0x20 {
+			s.params = append(s.params, byte(r))
+		} else {
+			s.control(r)
+		}
+	case stateOSC:
+		switch r {
+		case 0x07:
+			s.state = stateGround
+			s.oscDone()
+		case 0x1b:
+			s.state = stateOSCEscape
+		default:
+			s.osc = append(s.osc, string(r)...)
+		}
+	case stateOSCEscape:
+		s.state = stateGround
+		s.oscDone()
+		if r != '\\' {
+			s.handle(r)
+		}
+	case stateString:
+		if r == 0x1b {
+			s.state = stateStringEscape
+		} else if r == 0x07 {
+			s.state = stateGround
+		}
+	case stateStringEscape:
+		s.state = stateGround
+		if r != '\\' {
+			s.handle(r)
+		}
+	}
+}
+
+func (s *Screen) ground(r rune) {
+	if r < 0x20 || r == 0x7f {
+		s.control(r)
+		return
+	}
+	s.print(r)
+}
+
+func (s *Screen) control(r rune) {
+	switch r {
+	case 0x08:
+		s.wrapPending = false
+		if s.cursorX > 0 {
+			s.cursorX--
+		}
+	case 0x09:
+		s.cursorX = min((s.cursorX/8+1)*8, s.cols-1)
+	case 0x0a, 0x0b, 0x0c:
+		s.lineFeed()
+	case 0x0d:
+		s.wrapPending = false
+		s.cursorX = 0
+	case 0x1b:
+		s.state = stateEscape
+		s.params = s.params[:0]
+	}
+}
+
+func (s *Screen) escape(r rune) {
+	s.state = stateGround
+	switch r {
+	case '[':
+		s.state = stateCSI
+		s.params = s.params[:0]
+	case ']':
+		s.state = stateOSC
+		s.osc = s.osc[:0]
+	case 'P', 'X', '^', '_':
+		// DCS, SOS, PM and APC strings are ignored
+		s.state = stateString
+	case '(', ')', '*', '+':
+		s.state = stateEscapeCharset
+	case '7':
+		s.saveCursor()
+	case '8':
+		s.restoreCursor()
+	case 'D':
+		s.lineFeed()
+	case 'E':
+		s.cursorX = 0
+		s.lineFeed()
+	case 'M':
+		s.reverseIndex()
+	case 'c':
+		s.reset(s.cols, s.rows)
+	}
+}
+
+func (s *Screen) print(r rune) {
+	width := runewidth.RuneWidth(r)
+	if width == 0 {
+		// a combining character belongs to the previous cell
+		x, y := s.cursorX-1, s.cursorY
+		if s.wrapPending {
+			x = s.cursorX
+		}
+		if x >= 0 {
+			s.grid[y][x].Char += string(r)
+		}
+		return
+	}
+
+	if s.wrapPending || s.cursorX+width > s.cols {
+		if s.autowrap {
+			s.cursorX = 0
+			s.lineFeed()
+		} else {
+			s.cursorX = s.cols - width
+		}
+	}
+	s.wrapPending = false
+	if width > s.cols {
+		return
+	}
+
+	line := s.grid[s.cursorY]
+	line[s.cursorX] = Cell{Char: string(r), Style: s.style}
+	if width == 2 {
+		line[s.cursorX+1] = Cell{Style: s.style}
+	}
+	if s.cursorX+width >= s.cols {
+		s.cursorX = s.cols - 1
+		s.wrapPending = true
+	} else {
+		s.cursorX += width
+	}
+}
+
+func (s *Screen) lineFeed() {
+	s.wrapPending = false
+	if s.cursorY == s.scrollBottom {
+		s.scrollUp(1)
+	} else if s.cursorY < s.rows-1 {
+		s.cursorY++
+	}
+}
+
+func (s *Screen) reverseIndex() {
+	s.wrapPending = false
+	if s.cursorY == s.scrollTop {
+		s.scrollDown(1)
+	} else if s.cursorY > 0 {
+		s.cursorY--
+	}
+}
+
+// scrollUp moves the lines of the scroll region up, blank lines are added at the bottom
+func (s *Screen) scrollUp(n int) {
+	region := s.grid[s.scrollTop : s.scrollBottom+1]
+	n = min(n, len(region))
+	copy(region, region[n:])
+	for i := len(region) - n; i < len(region); i++ {
+		region[i] = newLine(s.cols)
+	}
+}
+
+// scrollDown moves the lines of the scroll region down, blank lines are added at the top
+func (s *Screen) scrollDown(n int) {
+	region := s.grid[s.scrollTop : s.scrollBottom+1]
+	n = min(n, len(region))
+	copy(region[n:], region)
+	for i := 0; i < n; i++ {
+		region[i] = newLine(s.cols)
+	}
+}
+
+func (s *Screen) saveCursor() {
+	s.saved = savedCursor{x: s.cursorX, y: s.cursorY, style: s.style}
+}
+
+func (s *Screen) restoreCursor() {
+	s.cursorX, s.cursorY, s.style = s.saved.x, s.saved.y, s.saved.style
+	s.wrapPending = false
+	s.clampCursor()
+}
+
+func (s *Screen) clampCursor() {
+	s.cursorX = max(0, min(s.cursorX, s.cols-1))
+	s.cursorY = max(0, min(s.cursorY, s.rows-1))
+}
+
+// csiParams parses the numeric parameters, the sub-parameters separated by
+// colons are treated the same as the ones separated by semicolons
+func (s *Screen) csiParams() (private byte, params []int) {
+	raw := string(s.params)
+	if len(raw) > 0 && strings.IndexByte("?<=>", raw[0]) >= 0 {
+		private, raw = raw[0], raw[1:]
+	}
+	raw = strings.TrimRight(raw, " !\"#$%&'()*+,-./")
+	if raw == "" {
+		return
+	}
+	for _, field := range strings.FieldsFunc(raw, func(r rune) bool { return r == ';' || r == ':' }) {
+		value, _ := strconv.Atoi(field)
+		params = append(params, value)
+	}
+	return
+}
+
+func param(params []int, index, defaultValue int) int {
+	if index < len(params) && params[index] > 0 {
+		return params[index]
+	}
+	return defaultValue
+}
+
+func (s *Screen) csi(final rune) {
+	private, params := s.csiParams()
+	if private == '?' {
+		if final == 'h' || final == 'l' {
+			for _, mode := range params {
+				s.setPrivateMode(mode, final == 'h')
+			}
+		}
+		return
+	}
+	if private != 0 {
+		return
+	}
+
+	n := param(params, 0, 1)
+	switch final {
+	case '@':
+		s.insertChars(n)
+	case 'A':
+		s.cursorY = max(s.cursorY-n, 0)
+	case 'B', 'e':
+		s.cursorY = min(s.cursorY+n, s.rows-1)
+	case 'C', 'a':
+		s.cursorX = min(s.cursorX+n, s.cols-1)
+	case 'D':
+		s.cursorX = max(s.cursorX-n, 0)
+	case 'E':
+		s.cursorX, s.cursorY = 0, min(s.cursorY+n, s.rows-1)
+	case 'F':
+		s.cursorX, s.cursorY = 0, max(s.cursorY-n, 0)
+	case 'G', '`':
+		s.cursorX = n - 1
+	case 'H', 'f':
+		s.cursorY, s.cursorX = n-1, param(params, 1, 1)-1
+	case 'J':
+		s.eraseDisplay(param(params, 0, 0))
+	case 'K':
+		s.eraseLine(param(params, 0, 0))
+	case 'L':
+		s.insertLines(n)
+	case 'M':
+		s.deleteLines(n)
+	case 'P':
+		s.deleteChars(n)
+	case 'S':
+		s.scrollUp(n)
+	case 'T':
+		s.scrollDown(n)
+	case 'X':
+		s.eraseRange(s.cursorY, s.cursorX, min(s.cursorX+n, s.cols))
+	case 'd':
+		s.cursorY = n - 1
+	case 'm':
+		s.setGraphicRendition(params)
+	case 'r':
+		top, bottom := param(params, 0, 1)-1, param(params, 1, s.rows)-1
+		if top < bottom && bottom < s.rows {
+			s.scrollTop, s.scrollBottom = top, bottom
+			s.cursorX, s.cursorY = 0, 0
+		}
+	case 's':
+		s.saveCursor()
+	case 'u':
+		s.restoreCursor()
+	}
+	s.wrapPending = false
+	s.clampCursor()
+}
+
+func (s *Screen) setPrivateMode(mode int, on bool) {
+	switch mode {
+	case 7:
+		s.autowrap = on
+	case 25:
+		s.cursorVisible = on
+	case 47, 1047:
+		s.switchScreen(on)
+	case 1049:
+		if on {
+			s.saveCursor()
+			s.switchScreen(true)
+			s.alt = newGrid(s.cols, s.rows)
+			s.grid = s.alt
+		} else {
+			s.switchScreen(false)
+			s.restoreCursor()
+		}
+	}
+}
+
+func (s *Screen) switchScreen(alternate bool) {
+	s.alternate = alternate
+	if alternate {
+		s.grid = s.alt
+	} else {
+		s.grid = s.main
+	}
+}
+
+func (s *Screen) setGraphicRendition(params []int) {
+	if len(params) == 0 {
+		params = []int{0}
+	}
+	for i := 0; i < len(params); i++ {
+		switch p := params[i]; {
+		case p == 0:
+			s.style = Style{}
+		case p == 1:
+			s.style.Bold = true
+		case p == 2:
+			s.style.Dim = true
+		case p == 3:
+			s.style.Italic = true
+		case p == 4:
+			s.style.Underline = true
+		case p == 7:
+			s.style.Inverse = true
+		case p == 9:
+			s.style.Strike = true
+		case p == 22:
+			s.style.Bold, s.style.Dim = false, false
+		case p == 23:
+			s.style.Italic = false
+		case p == 24:
+			s.style.Underline = false
+		case p == 27:
+			s.style.Inverse = false
+		case p == 29:
+			s.style.Strike = false
+		case p >= 30 && p <= 37:
+			s.style.FG = paletteColor(p - 30)
+		case p == 39:
+			s.style.FG = colorDefault
+		case p >= 40 && p <= 47:
+			s.style.BG = paletteColor(p - 40)
+		case p == 49:
+			s.style.BG = colorDefault
+		case p >= 90 && p <= 97:
+			s.style.FG = paletteColor(p - 90 + 8)
+		case p >= 100 && p <= 107:
+			s.style.BG = paletteColor(p - 100 + 8)
+		case p == 38 || p == 48:
+			var color Color
+			color, i = extendedColor(params, i)
+			if p == 38 {
+				s.style.FG = color
+			} else {
+				s.style.BG = color
+			}
+		}
+	}
+}
+
+// extendedColor parses 38;5;n and 38;2;r;g;b, it returns the index of the last used parameter
+func extendedColor(params []int, i int) (Color, int) {
+	if i+2 < len(params) && params[i+1] == 5 {
+		return paletteColor(params[i+2]), i + 2
+	}
+	if i+4 < len(params) && params[i+1] == 2 {
+		return rgbColor(params[i+2], params[i+3], params[i+4]), i + 4
+	}
+	return colorDefault, len(params)
+}
+
+func (s *Screen) oscDone() {
+	command, text, ok := strings.Cut(string(s.osc), ";")
+	if ok && (command == "0" || command == "2") {
+		s.title = text
+	}
+}
+
+func (s *Screen) eraseRange(y, from, to int) {
+	for x := from; x < to; x++ {
+		s.grid[y][x] = Cell{Char: " ", Style: Style{BG: s.style.BG}}
+	}
+}
+
+func (s *Screen) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		s.eraseRange(s.cursorY, s.cursorX, s.cols)
+		for y := s.cursorY + 1; y < s.rows; y++ {
+			s.eraseRange(y, 0, s.cols)
+		}
+	case 1:
+		for y := 0; y < s.cursorY; y++ {
+			s.eraseRange(y, 0, s.cols)
+		}
+		s.eraseRange(s.cursorY, 0, s.cursorX+1)
+	case 2, 3:
+		for y := 0; y < s.rows; y++ {
+			s.eraseRange(y, 0, s.cols)
+		}
+	}
+}
+
+func (s *Screen) eraseLine(mode int) {
+	switch mode {
+	case 0:
+		s.eraseRange(s.cursorY, s.cursorX, s.cols)
+	case 1:
+		s.eraseRange(s.cursorY, 0, s.cursorX+1)
+	case 2:
+		s.eraseRange(s.cursorY, 0, s.cols)
+	}
+}
+
+func (s *Screen) insertChars(n int) {
+	line := s.grid[s.cursorY]
+	n = min(n, s.cols-s.cursorX)
+	copy(line[s.cursorX+n:], line[s.cursorX:])
+	s.eraseRange(s.cursorY, s.cursorX, s.cursorX+n)
+}
+
+func (s *Screen) deleteChars(n int) {
+	line := s.grid[s.cursorY]
+	n = min(n, s.cols-s.cursorX)
+	copy(line[s.cursorX:], line[s.cursorX+n:])
+	s.eraseRange(s.cursorY, s.cols-n, s.cols)
+}
+
+func (s *Screen) insertLines(n int) {
+	if s.cursorY < s.scrollTop || s.cursorY > s.scrollBottom {
+		return
+	}
+	top := s.scrollTop
+	s.scrollTop = s.cursorY
+	s.scrollDown(n)
+	s.scrollTop = top
+	s.cursorX = 0
+}
+
+func (s *Screen) deleteLines(n int) {
+	if s.cursorY < s.scrollTop || s.cursorY > s.scrollBottom {
+		return
+	}
+	top := s.scrollTop
+	s.scrollTop = s.cursorY
+	s.scrollUp(n)
+	s.scrollTop = top
+	s.cursorX = 0
+}
+
+// Resize changes the size of the screen, the content is cut or padded
+func (s *Screen) Resize(cols, rows int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	resize := func(grid [][]Cell) [][]Cell {
+		// keep the bottom lines, which is where the cursor usually is
+		if len(grid) > rows {
+			grid = grid[len(grid)-rows:]
+		}
+		for len(grid) < rows {
+			grid = append(grid, newLine(cols))
+		}
+		for y, line := range grid {
+			if len(line) > cols {
+				grid[y] = line[:cols]
+			} else if len(line) < cols {
+				grid[y] = append(line, newLine(cols-len(line))...)
+			}
+		}
+		return grid
+	}
+	if s.rows > rows {
+		s.cursorY -= s.rows - rows
+	}
+	s.main, s.alt = resize(s.main), resize(s.alt)
+	s.switchScreen(s.alternate)
+	s.cols, s.rows = cols, rows
+	s.scrollTop, s.scrollBottom = 0, rows-1
+	s.wrapPending = false
+	s.clampCursor()
+}
+
+// ScreenSnapshot is the visible state of the screen
+type ScreenSnapshot struct {
+	Cols            int      `json:"cols"`
+	Rows            int      `json:"rows"`
+	Title           string   `json:"title"`
+	AlternateScreen bool     `json:"alternateScreen"`
+	Cursor          Cursor   `json:"cursor"`
+	Lines           []string `json:"lines"`
+	Cells           [][]Cell `json:"cells,omitempty"`
+}
+
+// Cursor is the position of the cursor, counted from zero
+type Cursor struct {
+	X       int  `json:"x"`
+	Y       int  `json:"y"`
+	Visible bool `json:"visible"`
+}
+
+// Snapshot returns the screen, cells are included only if it's asked
+func (s *Screen) Snapshot(withCells bool) ScreenSnapshot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	snapshot := ScreenSnapshot{
+		Cols:            s.cols,
+		Rows:            s.rows,
+		Title:           s.title,
+		AlternateScreen: s.alternate,
+		Cursor:          Cursor{X: s.cursorX, Y: s.cursorY, Visible: s.cursorVisible},
+		Lines:           make([]string, s.rows),
+	}
+	for y, line := range s.grid {
+		var text strings.Builder
+		for _, cell := range line {
+			text.WriteString(cell.Char)
+		}
+		snapshot.Lines[y] = strings.TrimRight(text.String(), " ")
+		if withCells {
+			snapshot.Cells = append(snapshot.Cells, append([]Cell{}, line...))
+		}
+	}
+	return snapshot
+}
+
+// Text returns the visible screen as plain text
+func (s *Screen) Text() string {
+	return strings.TrimRight(strings.Join(s.Snapshot(false).Lines, "\n"), "\n")
+}
+
+// ANSI returns the escape sequences which draw the visible screen, and then
+// put the cursor back, on a terminal of the same size
+func (s *Screen) ANSI() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var out strings.Builder
+	out.WriteString("\x1b[H\x1b[2J")
+	for y, line := range s.grid {
+		current := Style{}
+		out.WriteString(fmt.Sprintf("\x1b[%d;1H\x1b[0m", y+1))
+		for _, cell := range line {
+			if cell.Char == "" {
+				continue
+			}
+			if cell.Style != current {
+				out.WriteString(cell.Style.sgr())
+				current = cell.Style
+			}
+			out.WriteString(cell.Char)
+		}
+	}
+	out.WriteString("\x1b[0m")
+	out.WriteString(s.style.sgr())
+	out.WriteString(fmt.Sprintf("\x1b[%d;%dH", s.cursorY+1, s.cursorX+1))
+	if !s.cursorVisible {
+		out.WriteString("\x1b[?25l")
+	}
+	return out.String()
+}
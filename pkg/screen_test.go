@@ -0,0 +1,162 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScreen(t *testing.T) {
+	tests := []struct {
+		name         string
+		cols, rows   int
+		writes       []string
+		expectLines  []string
+		expectCursor Cursor
+	}{{
+		name:         "new lines",
+		writes:       []string{"ab\r\ncd"},
+		expectLines:  []string{"ab", "cd", ""},
+		expectCursor: Cursor{X: 2, Y: 1, Visible: true},
+	}, {
+		name:         "cursor moves",
+		writes:       []string{"\x1b[2;3Hx\x1b[Ay\x1b[2Dz"},
+		expectLines:  []string{"  zy", "  x", ""},
+		expectCursor: Cursor{X: 3, Y: 0, Visible: true},
+	}, {
+		name:         "cursor moves are clamped",
+		writes:       []string{"\x1b[9;9H\x1b[20D\x1b[B"},
+		expectLines:  []string{"", "", ""},
+		expectCursor: Cursor{X: 0, Y: 2, Visible: true},
+	}, {
+		name:         "autowrap",
+		writes:       []string{"abcdefg"},
+		expectLines:  []string{"abcde", "fg", ""},
+		expectCursor: Cursor{X: 2, Y: 1, Visible: true},
+	}, {
+		name:         "carriage return after the last column",
+		writes:       []string{"abcde\rx"},
+		expectLines:  []string{"xbcde", "", ""},
+		expectCursor: Cursor{X: 1, Y: 0, Visible: true},
+	}, {
+		name:         "scroll at the bottom",
+		writes:       []string{"1\r\n2\r\n3\r\n4"},
+		expectLines:  []string{"2", "3", "4"},
+		expectCursor: Cursor{X: 1, Y: 2, Visible: true},
+	}, {
+		name:         "scroll region",
+		rows:         4,
+		writes:       []string{"1\r\n2\r\n3\r\n4", "\x1b[2;3r\x1b[3;1H\n"},
+		expectLines:  []string{"1", "3", "", "4"},
+		expectCursor: Cursor{X: 0, Y: 2, Visible: true},
+	}, {
+		name:         "reverse index at the top of the scroll region",
+		rows:         4,
+		writes:       []string{"1\r\n2\r\n3\r\n4", "\x1b[2;3r\x1b[2;1H\x1bM"},
+		expectLines:  []string{"1", "", "2", "4"},
+		expectCursor: Cursor{X: 0, Y: 1, Visible: true},
+	}, {
+		name:         "insert and delete lines",
+		rows:         4,
+		writes:       []string{"1\r\n2\r\n3\r\n4", "\x1b[2;1H\x1b[L\x1b[4;1H\x1b[M"},
+		expectLines:  []string{"1", "", "2", ""},
+		expectCursor: Cursor{X: 0, Y: 3, Visible: true},
+	}, {
+		name:         "erase to the end of the line",
+		writes:       []string{"abcde\x1b[3D\x1b[K"},
+		expectLines:  []string{"a", "", ""},
+		expectCursor: Cursor{X: 1, Y: 0, Visible: true},
+	}, {
+		name:         "erase to the cursor",
+		writes:       []string{"ab\r\ncdef\x1b[3G\x1b[1J"},
+		expectLines:  []string{"", "   f", ""},
+		expectCursor: Cursor{X: 2, Y: 1, Visible: true},
+	}, {
+		name:         "erase the display",
+		writes:       []string{"ab\r\ncd\x1b[2J"},
+		expectLines:  []string{"", "", ""},
+		expectCursor: Cursor{X: 2, Y: 1, Visible: true},
+	}, {
+		name:         "erase characters",
+		writes:       []string{"abcde\x1b[1G\x1b[2X"},
+		expectLines:  []string{"  cde", "", ""},
+		expectCursor: Cursor{X: 0, Y: 0, Visible: true},
+	}, {
+		name:         "insert and delete characters",
+		writes:       []string{"abcde\x1b[1G\x1b[2@\r\nabcde\x1b[2G\x1b[2P"},
+		expectLines:  []string{"  abc", "ade", ""},
+		expectCursor: Cursor{X: 1, Y: 1, Visible: true},
+	}, {
+		name:         "wide runes",
+		writes:       []string{"中文字"},
+		expectLines:  []string{"中文", "字", ""},
+		expectCursor: Cursor{X: 2, Y: 1, Visible: true},
+	}, {
+		name:         "combining character",
+		writes:       []string{"e\u0301x"},
+		expectLines:  []string{"e\u0301x", "", ""},
+		expectCursor: Cursor{X: 2, Y: 0, Visible: true},
+	}, {
+		name:         "character split between writes",
+		writes:       []string{"\xe4\xb8", "\xad"},
+		expectLines:  []string{"中", "", ""},
+		expectCursor: Cursor{X: 2, Y: 0, Visible: true},
+	}, {
+		name:         "alternate screen",
+		writes:       []string{"main\x1b[?1049hALT", "\x1b[?1049l"},
+		expectLines:  []string{"main", "", ""},
+		expectCursor: Cursor{X: 4, Y: 0, Visible: true},
+	}, {
+		name:         "full reset",
+		writes:       []string{"\x1b]0;title\x07abc\x1b[?25l\x1b[31m\x1b[?1049h\x1b[2;3r", "\x1bc"},
+		expectLines:  []string{"", "", ""},
+		expectCursor: Cursor{X: 0, Y: 0, Visible: true},
+	}, {
+		name:         "full reset split between writes",
+		writes:       []string{"abc\x1b[?25l\x1b", "c", "x"},
+		expectLines:  []string{"x", "", ""},
+		expectCursor: Cursor{X: 1, Y: 0, Visible: true},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			screen := NewScreen(max(tt.cols, 5), max(tt.rows, 3))
+			for _, data := range tt.writes {
+				n, err := screen.Write([]byte(data))
+				assert.NoError(t, err)
+				assert.Equal(t, len(data), n)
+			}
+			snapshot := screen.Snapshot(false)
+			assert.Equal(t, tt.expectLines, snapshot.Lines)
+			assert.Equal(t, tt.expectCursor, snapshot.Cursor)
+		})
+	}
+}
+
+func TestScreenReset(t *testing.T) {
+	screen := NewScreen(5, 3)
+	_, _ = screen.Write([]byte("\x1b]0;title\x07\x1b[31mab\x1b[?1049h\x1b[2;3r\x1bc"))
+	snapshot := screen.Snapshot(true)
+	assert.Equal(t, "", snapshot.Title)
+	assert.False(t, snapshot.AlternateScreen)
+
+	// the style and the scroll region are the defaults again
+	_, _ = screen.Write([]byte("x\x1b[3;1H\n"))
+	snapshot = screen.Snapshot(true)
+	assert.Equal(t, Style{}, snapshot.Cells[0][0].Style)
+	assert.Equal(t, []string{"", "", ""}, snapshot.Lines)
+}
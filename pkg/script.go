@@ -0,0 +1,303 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/tidwall/gjson"
+)
+
+// ScriptRequest runs the steps in order as a single job, the variables are
+// available to the templates of all the steps
+type ScriptRequest struct {
+	Profile   string            `json:"profile,omitempty" yaml:"profile,omitempty"`
+	Variables map[string]string `json:"variables,omitempty" yaml:"variables,omitempty"`
+	Steps     []ScriptStep      `json:"steps" yaml:"steps"`
+}
+
+// ScriptStep is a command rendered as a Go template with the sprig functions,
+// for instance: curl {{.host}}/items/{{.id | trim}}
+type ScriptStep struct {
+	Name            string      `json:"name,omitempty" yaml:"name,omitempty"`
+	Cmd             string      `json:"cmd" yaml:"cmd"`
+	Profile         string      `json:"profile,omitempty" yaml:"profile,omitempty"`
+	Timeout         string      `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	Captures        []Capture   `json:"captures,omitempty" yaml:"captures,omitempty"`
+	Assertions      []Assertion `json:"assertions,omitempty" yaml:"assertions,omitempty"`
+	ContinueOnError bool        `json:"continueOnError,omitempty" yaml:"continueOnError,omitempty"`
+}
+
+// Capture stores a value of the output into a variable. It's the first group
+// of the regex, or the whole match without a group; the value of the gjson
+// path; or the trimmed output when neither of them is set.
+type Capture struct {
+	Name   string `json:"name" yaml:"name"`
+	Regex  string `json:"regex,omitempty" yaml:"regex,omitempty"`
+	JSON   string `json:"json,omitempty" yaml:"json,omitempty"`
+	Stream string `json:"stream,omitempty" yaml:"stream,omitempty"`
+}
+
+// ScriptResult is the result of the steps which have run
+type ScriptResult struct {
+	Success   bool               `json:"success"`
+	Steps     []ScriptStepResult `json:"steps"`
+	Variables map[string]string  `json:"variables"`
+	Duration  string             `json:"duration"`
+}
+
+// ScriptStepResult is the result of a step, a step fails when it exits with
+// a non-zero code, or one of its captures or assertions fails
+type ScriptStepResult struct {
+	Name     string            `json:"name,omitempty"`
+	Cmd      string            `json:"cmd"`
+	Success  bool              `json:"success"`
+	Captures map[string]string `json:"captures,omitempty"`
+	execResponse
+}
+
+// validate checks the steps before anything runs
+func (r ScriptRequest) validate() error {
+	if len(r.Steps) == 0 {
+		return errors.New("no steps")
+	}
+	for i, step := range r.Steps {
+		if err := step.validate(); err != nil {
+			return fmt.Errorf("step %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (s ScriptStep) validate() (err error) {
+	if strings.TrimSpace(s.Cmd) == "" {
+		return errors.New("no cmd")
+	}
+	if _, err = scriptTemplate(s.Cmd); err != nil {
+		return
+	}
+	if _, err = parseTimeout(s.Timeout, execTimeout); err != nil {
+		return
+	}
+	for _, capture := range s.Captures {
+		if err = capture.validate(); err != nil {
+			return
+		}
+	}
+	return validateAssertions(s.Assertions)
+}
+
+func (c Capture) validate() (err error) {
+	switch {
+	case c.Name == "":
+		err = errors.New("a capture needs a name")
+	case c.Regex != "" && c.JSON != "":
+		err = fmt.Errorf("capture %q can't have both regex and json", c.Name)
+	case c.Regex != "":
+		_, err = regexp.Compile(c.Regex)
+	}
+	if err == nil && c.Stream != "" && c.Stream != AssertStdout && c.Stream != AssertStderr {
+		err = fmt.Errorf("unknown stream: %q", c.Stream)
+	}
+	return
+}
+
+// scriptFuncs are the sprig functions except the ones which read the
+// environment of the server, it's not the one of the commands
+var scriptFuncs = func() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	delete(funcs, "env")
+	delete(funcs, "expandenv")
+	return funcs
+}()
+
+func scriptTemplate(text string) (*template.Template, error) {
+	return template.New("cmd").Funcs(scriptFuncs).Option("missingkey=error").Parse(text)
+}
+
+// render executes the template of the command with the variables
+func (s ScriptStep) render(variables map[string]string) (cmd string, err error) {
+	var tpl *template.Template
+	if tpl, err = scriptTemplate(s.Cmd); err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err = tpl.Execute(&buf, variables); err == nil {
+		cmd = buf.String()
+	}
+	return
+}
+
+// capture returns the captured value from the response
+func (c Capture) capture(resp execResponse) (value string, err error) {
+	output := resp.Stdout
+	if c.Stream == AssertStderr {
+		output = resp.Stderr
+	}
+
+	switch {
+	case c.Regex != "":
+		groups := regexp.MustCompile(c.Regex).FindStringSubmatch(output)
+		switch {
+		case groups == nil:
+			err = fmt.Errorf("capture %q: %q not matched", c.Name, c.Regex)
+		case len(groups) > 1:
+			value = groups[1]
+		default:
+			value = groups[0]
+		}
+	case c.JSON != "":
+		result := gjson.Get(output, c.JSON)
+		if !result.Exists() {
+			err = fmt.Errorf("capture %q: %s not found", c.Name, c.JSON)
+		}
+		value = result.String()
+	default:
+		value = strings.TrimSpace(output)
+	}
+	return
+}
+
+// runScript runs the steps in order, it stops at the first failed step
+// unless the step continues on error
+func (s *ExecServer) runScript(ctx context.Context, req ScriptRequest) (result ScriptResult) {
+	begin := s.clock.Now()
+	result = ScriptResult{
+		Success:   true,
+		Steps:     []ScriptStepResult{},
+		Variables: map[string]string{},
+	}
+	for key, value := range req.Variables {
+		result.Variables[key] = value
+	}
+
+	for _, step := range req.Steps {
+		stepResult := s.runScriptStep(ctx, req, step, result.Variables)
+		result.Steps = append(result.Steps, stepResult)
+		if !stepResult.Success {
+			result.Success = false
+			if !step.ContinueOnError || ctx.Err() != nil {
+				break
+			}
+		}
+	}
+	result.Duration = s.clock.Now().Sub(begin).String()
+	return
+}
+
+func (s *ExecServer) runScriptStep(ctx context.Context, req ScriptRequest, step ScriptStep, variables map[string]string) (result ScriptStepResult) {
+	result.Name = step.Name
+	fail := func(err error) ScriptStepResult {
+		result.Error = err.Error()
+		result.ExitCode = -1
+		return result
+	}
+
+	cmd, err := step.render(variables)
+	if err != nil {
+		return fail(err)
+	}
+	result.Cmd = s.redact(cmd)
+
+	profile, err := s.getProfile(cmp.Or(step.Profile, req.Profile))
+	if err != nil {
+		return fail(err)
+	}
+	execReq := execRequest{Cmd: cmd, Profile: profile.Name, Assertions: step.Assertions}
+	execReq.withProfile(profile)
+
+	timeout, _ := parseTimeout(step.Timeout, execTimeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	command, _, err := prepareCommand(ctx, s.runner, s.policies.get(), execReq, profile)
+	if err != nil {
+		return fail(err)
+	}
+	result.execResponse = s.runCommand(command, execReq)
+	result.assert(step.Assertions)
+	result.Success = result.ExitCode == 0 && (result.Passed == nil || *result.Passed)
+
+	for _, capture := range step.Captures {
+		value, err := capture.capture(result.execResponse)
+		if err != nil {
+			result.Success = false
+			result.Error = strings.TrimPrefix(result.Error+"; "+err.Error(), "; ")
+			continue
+		}
+		if result.Captures == nil {
+			result.Captures = map[string]string{}
+		}
+		result.Captures[capture.Name] = value
+		variables[capture.Name] = value
+	}
+	return
+}
+
+// handleScript runs a script and responds with the result of each step
+func (s *ExecServer) handleScript(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ScriptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the steps run one by one, so the script takes a single command slot
+	release, err := s.commandLimiter.acquire(r.Context(), requestUser(r), nil)
+	if errors.Is(err, errQueueFull) {
+		loggerFrom(r.Context()).Warn("script rejected", "error", err)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	} else if err != nil {
+		// the client is gone while waiting
+		return
+	}
+	defer release()
+
+	done, err := s.startSession()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer done()
+
+	// the script stops when the client goes away or the server shuts down
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	defer context.AfterFunc(r.Context(), cancel)()
+	_ = json.NewEncoder(w).Encode(s.runScript(ctx, req))
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScriptStepRender(t *testing.T) {
+	tests := []struct {
+		name        string
+		cmd         string
+		expectCmd   string
+		expectError string
+	}{{
+		name:      "variables and sprig functions",
+		cmd:       `curl {{.host}}/{{.user | lower}}`,
+		expectCmd: "curl http://localhost/admin",
+	}, {
+		name:        "env",
+		cmd:         `echo {{env "HOME"}}`,
+		expectError: `function "env" not defined`,
+	}, {
+		name:        "expandenv",
+		cmd:         `echo {{expandenv "$HOME"}}`,
+		expectError: `function "expandenv" not defined`,
+	}, {
+		name:        "missing variable",
+		cmd:         `echo {{.token}}`,
+		expectError: `map has no entry for key "token"`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := ScriptStep{Cmd: tt.cmd}.render(map[string]string{"host": "http://localhost", "user": "Admin"})
+			if tt.expectError == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectCmd, cmd)
+			} else {
+				assert.ErrorContains(t, err, tt.expectError)
+			}
+		})
+	}
+}
+
+func TestHandleScriptLimit(t *testing.T) {
+	runner := &FakeRunner{ExpectStdout: "Admin\n"}
+	server := newTestServer(t, runner)
+	server.commandLimiter.setLimits(1, 0, 0)
+	release, err := server.commandLimiter.acquire(context.Background(), "other", nil)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		body := strings.NewReader(`{"steps": [{"cmd": "whoami", "captures": [{"name": "user"}]}, {"cmd": "echo {{.user}}"}]}`)
+		server.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/scripts", body))
+	}()
+
+	// the script waits for the slot of the other user
+	assert.Eventually(t, func() bool { return server.commandLimiter.queueDepth() == 1 }, time.Second, 10*time.Millisecond)
+	assert.Empty(t, runner.Commands())
+	release()
+	<-finished
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var result ScriptResult
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	assert.True(t, result.Success)
+	assert.Len(t, runner.Commands(), 2)
+	assert.Equal(t, "echo Admin", result.Steps[1].Cmd)
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SeccompExecCommand is the hidden sub-command which brings up the loopback
+// interface of an isolated command and installs its seccomp filter, and then
+// replaces itself with the real command
+const SeccompExecCommand = "seccomp-exec"
+
+// DefaultSeccompProfile is the name of the built-in profile
+const DefaultSeccompProfile = "default"
+
+// Seccomp actions, named the same as in the OCI runtime spec
+const (
+	SeccompActKill        = "SCMP_ACT_KILL"
+	SeccompActKillProcess = "SCMP_ACT_KILL_PROCESS"
+	SeccompActKillThread  = "SCMP_ACT_KILL_THREAD"
+	SeccompActTrap        = "SCMP_ACT_TRAP"
+	SeccompActErrno       = "SCMP_ACT_ERRNO"
+	SeccompActLog         = "SCMP_ACT_LOG"
+	SeccompActAllow       = "SCMP_ACT_ALLOW"
+)
+
+// SeccompProfile is a syscall filter in a format similar to the OCI seccomp
+// profiles. It can be written in JSON or YAML.
+type SeccompProfile struct {
+	DefaultAction   string           `json:"defaultAction" yaml:"defaultAction"`
+	DefaultErrnoRet *uint            `json:"defaultErrnoRet,omitempty" yaml:"defaultErrnoRet,omitempty"`
+	Syscalls        []SeccompSyscall `json:"syscalls,omitempty" yaml:"syscalls,omitempty"`
+}
+
+// SeccompSyscall applies an action to a group of syscalls
+type SeccompSyscall struct {
+	Names    []string `json:"names" yaml:"names"`
+	Action   string   `json:"action" yaml:"action"`
+	ErrnoRet *uint    `json:"errnoRet,omitempty" yaml:"errnoRet,omitempty"`
+}
+
+// defaultSeccompProfile allows everything except the syscalls which could
+// affect the host or other processes. The ones which only change the host
+// kill the command, so they're reported as violations. The ones which the
+// common tools probe, for instance ptrace or keyctl, fail with EPERM instead.
+var defaultSeccompProfile = SeccompProfile{
+	DefaultAction: SeccompActAllow,
+	Syscalls: []SeccompSyscall{{
+		Names: []string{
+			"acct", "clock_adjtime", "clock_settime", "delete_module", "finit_module", "init_module",
+			"kexec_file_load", "kexec_load", "reboot", "setdomainname", "sethostname", "settimeofday",
+			"swapoff", "swapon",
+		},
+		Action: SeccompActKillProcess,
+	}, {
+		Names: []string{
+			"add_key", "bpf", "fsconfig", "fsmount", "fsopen", "fspick", "keyctl", "mount", "move_mount",
+			"open_by_handle_at", "open_tree", "perf_event_open", "pivot_root", "process_vm_readv",
+			"process_vm_writev", "ptrace", "quotactl", "request_key", "setns", "syslog", "umount2",
+			"userfaultfd",
+		},
+		Action: SeccompActErrno,
+	}},
+}
+
+// readSeccompProfiles reads all the profiles (*.json, *.yaml, *.yml) in the
+// directory, the file name without extension is the profile name. The
+// built-in one is included.
+func readSeccompProfiles(dir string) (profiles map[string]SeccompProfile, err error) {
+	profiles = map[string]SeccompProfile{
+		DefaultSeccompProfile: defaultSeccompProfile,
+	}
+	if dir == "" {
+		return
+	}
+
+	var entries []os.DirEntry
+	if entries, err = os.ReadDir(dir); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".json" && ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+
+		var profile SeccompProfile
+		if profile, err = readSeccompProfile(filepath.Join(dir, entry.Name())); err != nil {
+			return nil, err
+		}
+		profiles[strings.TrimSuffix(entry.Name(), ext)] = profile
+	}
+	return
+}
+
+func readSeccompProfile(file string) (profile SeccompProfile, err error) {
+	var data []byte
+	if data, err = os.ReadFile(file); err != nil {
+		return
+	}
+	// JSON is a subset of YAML, so the YAML parser handles both of them
+	if err = yaml.Unmarshal(data, &profile); err != nil {
+		err = fmt.Errorf("invalid seccomp profile %s: %w", file, err)
+		return
+	}
+	if err = profile.validate(); err != nil {
+		err = fmt.Errorf("invalid seccomp profile %s: %w", file, err)
+	}
+	return
+}
+
+func getSeccompProfile(profiles map[string]SeccompProfile, name string) (profile SeccompProfile, err error) {
+	profile, ok := profiles[name]
+	if !ok {
+		err = fmt.Errorf("seccomp profile %q not found", name)
+	}
+	return
+}
+
+func (p SeccompProfile) validate() error {
+	if _, err := seccompAction(p.DefaultAction, p.DefaultErrnoRet); err != nil {
+		return fmt.Errorf("defaultAction: %w", err)
+	}
+	for i, item := range p.Syscalls {
+		if _, err := seccompAction(item.Action, item.ErrnoRet); err != nil {
+			return fmt.Errorf("syscalls[%d].action: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// seccompAction converts the action name to the return value of the filter
+func seccompAction(action string, errnoRet *uint) (uint32, error) {
+	switch action {
+	case SeccompActKill, SeccompActKillThread:
+		return 0x00000000, nil
+	case SeccompActKillProcess:
+		return 0x80000000, nil
+	case SeccompActTrap:
+		return 0x00030000, nil
+	case SeccompActErrno:
+		errno := uint32(syscall.EPERM)
+		if errnoRet != nil {
+			errno = uint32(*errnoRet)
+		}
+		return 0x00050000 | (errno & 0xffff), nil
+	case SeccompActLog:
+		return 0x7ffc0000, nil
+	case SeccompActAllow:
+		return 0x7fff0000, nil
+	default:
+		return 0, fmt.Errorf("unsupported action %q", action)
+	}
+}
+
+// SeccompViolationError means the command was killed by SIGSYS because it
+// called a syscall which the seccomp profile kills or traps
+type SeccompViolationError struct {
+	Profile string
+}
+
+func (e *SeccompViolationError) Error() string {
+	return fmt.Sprintf("command was terminated by the seccomp profile %q: it tried to call a blocked syscall", e.Profile)
+}
+
+// checkSeccomp returns the error of a finished command like seccompViolation,
+// and counts the violations in the metrics of the server
+func (s *ExecServer) checkSeccomp(profile string, err error) error {
+	err = seccompViolation(profile, err)
+	var violation *SeccompViolationError
+	if errors.As(err, &violation) {
+		s.metrics.policyDenied("seccomp")
+	}
+	return err
+}
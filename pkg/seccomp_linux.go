@@ -0,0 +1,222 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// the options of the seccomp-exec helper, which come before the command
+const (
+	execHelperLoopback = "--loopback"
+	execHelperProfile  = "--profile="
+)
+
+// seccompSupported tells if the seccomp profiles work on this architecture
+func seccompSupported() bool {
+	return seccompAuditArch != 0
+}
+
+// applySeccomp makes the command start through the seccomp-exec helper, which
+// installs the filter of the named profile right before executing the command
+func applySeccomp(cmd *exec.Cmd, profiles map[string]SeccompProfile, name string) (err error) {
+	if name == "" {
+		return
+	}
+	if !seccompSupported() {
+		return fmt.Errorf("seccomp profiles are not supported on %s", runtime.GOARCH)
+	}
+
+	var profile SeccompProfile
+	if profile, err = getSeccompProfile(profiles, name); err != nil {
+		return
+	}
+
+	var data []byte
+	if data, err = json.Marshal(profile); err != nil {
+		return
+	}
+
+	return wrapExecHelper(cmd, execHelperProfile+string(data))
+}
+
+// wrapExecHelper makes the command start through the seccomp-exec helper with
+// the option, it's added to the options of the helper if it's wrapped already
+func wrapExecHelper(cmd *exec.Cmd, option string) (err error) {
+	var self string
+	if self, err = os.Executable(); err != nil {
+		return
+	}
+	if cmd.Path == self && len(cmd.Args) > 1 && cmd.Args[1] == SeccompExecCommand {
+		cmd.Args = slices.Insert(cmd.Args, 2, option)
+		return
+	}
+	cmd.Args = append([]string{self, SeccompExecCommand, option, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = self
+	return
+}
+
+// RunSeccompExec brings up the loopback interface and installs the seccomp
+// profile as the options ask, then replaces the current process with the
+// command of the remaining arguments
+func RunSeccompExec(args []string) (err error) {
+	var loopback bool
+	var profile *SeccompProfile
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		option := args[0]
+		args = args[1:]
+		switch {
+		case option == execHelperLoopback:
+			loopback = true
+		case strings.HasPrefix(option, execHelperProfile):
+			profile = &SeccompProfile{}
+			if err = json.Unmarshal([]byte(strings.TrimPrefix(option, execHelperProfile)), profile); err != nil {
+				return fmt.Errorf("invalid seccomp profile: %w", err)
+			}
+		default:
+			return fmt.Errorf("unknown option %q", option)
+		}
+	}
+	if len(args) == 0 {
+		return errors.New("usage: seccomp-exec [--loopback] [--profile=<profile>] <command> [args...]")
+	}
+
+	var filter []unix.SockFilter
+	if profile != nil {
+		if filter, err = profile.compile(); err != nil {
+			return
+		}
+	}
+
+	var path string
+	if path, err = exec.LookPath(args[0]); err != nil {
+		return
+	}
+
+	// the filter is installed on all threads, but make sure exec happens on
+	// the same one anyway
+	runtime.LockOSThread()
+	if loopback {
+		if err = setLoopbackUp(); err != nil {
+			return fmt.Errorf("failed to bring up the loopback interface: %w", err)
+		}
+	}
+	if filter != nil {
+		if err = installSeccompFilter(filter); err != nil {
+			return fmt.Errorf("failed to install seccomp filter: %w", err)
+		}
+	}
+	if err = syscall.Exec(path, args, os.Environ()); err != nil {
+		err = fmt.Errorf("failed to exec %s: %w", path, err)
+	}
+	return
+}
+
+// compile translates the profile into a BPF program, the first rule which
+// matches the syscall number decides the action
+func (p SeccompProfile) compile() (filter []unix.SockFilter, err error) {
+	var defaultAction uint32
+	if defaultAction, err = seccompAction(p.DefaultAction, p.DefaultErrnoRet); err != nil {
+		return
+	}
+
+	program := []bpf.Instruction{
+		// seccomp_data.arch, syscall numbers only make sense for the native one
+		bpf.LoadAbsolute{Off: 4, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: seccompAuditArch, SkipTrue: 1},
+		bpf.RetConstant{Val: unix.SECCOMP_RET_KILL_PROCESS},
+		// seccomp_data.nr
+		bpf.LoadAbsolute{Off: 0, Size: 4},
+	}
+	program = append(program, seccompArchPrelude...)
+	for _, item := range p.Syscalls {
+		var action uint32
+		if action, err = seccompAction(item.Action, item.ErrnoRet); err != nil {
+			return
+		}
+		for _, name := range item.Names {
+			nr, ok := seccompSyscalls[name]
+			if !ok {
+				// unknown, or not available on this architecture
+				continue
+			}
+			program = append(program,
+				bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: nr, SkipTrue: 1},
+				bpf.RetConstant{Val: action})
+		}
+	}
+	program = append(program, bpf.RetConstant{Val: defaultAction})
+
+	var raw []bpf.RawInstruction
+	if raw, err = bpf.Assemble(program); err != nil {
+		return
+	}
+	filter = make([]unix.SockFilter, len(raw))
+	for i, ins := range raw {
+		filter[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	return
+}
+
+func installSeccompFilter(filter []unix.SockFilter) error {
+	// required for installing a filter without CAP_SYS_ADMIN
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return err
+	}
+
+	prog := unix.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+	_, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER,
+		unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// seccompViolation checks if the error of a finished command was caused by
+// the seccomp filter, the kernel kills it with SIGSYS in that case. The
+// syscalls which are denied with SCMP_ACT_ERRNO only fail, they're not
+// violations.
+func seccompViolation(profile string, err error) error {
+	if profile == "" || err == nil {
+		return err
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	// an exit code of 128+SIGSYS is not trusted, the command can exit with it
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGSYS {
+		return &SeccompViolationError{Profile: profile}
+	}
+	return err
+}
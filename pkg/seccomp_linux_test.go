@@ -0,0 +1,112 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapExecHelper(t *testing.T) {
+	self, err := os.Executable()
+	require.NoError(t, err)
+
+	cmd := exec.Command("/bin/sh", "-c", "echo hi")
+	require.NoError(t, wrapExecHelper(cmd, execHelperLoopback))
+	require.NoError(t, wrapExecHelper(cmd, execHelperProfile+"{}"))
+	assert.Equal(t, self, cmd.Path)
+	assert.Equal(t, []string{self, SeccompExecCommand, execHelperProfile + "{}", execHelperLoopback, "/bin/sh", "-c", "echo hi"}, cmd.Args)
+}
+
+func TestSeccompViolation(t *testing.T) {
+	tests := []struct {
+		name            string
+		script          string
+		profile         string
+		expectViolation bool
+	}{{
+		name:            "killed by SIGSYS",
+		script:          "kill -SYS $$",
+		profile:         DefaultSeccompProfile,
+		expectViolation: true,
+	}, {
+		name:    "exit code of SIGSYS",
+		script:  "exit 159",
+		profile: DefaultSeccompProfile,
+	}, {
+		name:   "without profile",
+		script: "kill -SYS $$",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := seccompViolation(tt.profile, exec.Command("sh", "-c", tt.script).Run())
+			var violation *SeccompViolationError
+			assert.Error(t, err)
+			assert.Equal(t, tt.expectViolation, errors.As(err, &violation), err)
+		})
+	}
+}
+
+// the test binary calls the syscall of this variable when it's set
+const seccompSyscallEnv = "ATEST_TERMINAL_SECCOMP_SYSCALL"
+
+func TestDefaultSeccompProfile(t *testing.T) {
+	switch os.Getenv(seccompSyscallEnv) {
+	case "":
+	case "reboot":
+		// the invalid magic numbers make it fail with EINVAL if it's allowed
+		_, _, _ = syscall.Syscall6(syscall.SYS_REBOOT, 0, 0, 0, 0, 0, 0)
+		os.Exit(0)
+	case "ptrace":
+		_, _, _ = syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_PEEKDATA, 1, 0, 0, 0, 0)
+		os.Exit(0)
+	}
+	if !seccompSupported() {
+		t.Skip("seccomp profiles are not supported")
+	}
+
+	self, err := os.Executable()
+	require.NoError(t, err)
+	tests := []struct {
+		syscall         string
+		expectViolation bool
+	}{{
+		syscall:         "reboot",
+		expectViolation: true,
+	}, {
+		syscall: "ptrace",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.syscall, func(t *testing.T) {
+			cmd := exec.Command(self, "-test.run=^TestDefaultSeccompProfile$")
+			cmd.Env = append(os.Environ(), seccompSyscallEnv+"="+tt.syscall)
+			require.NoError(t, applySeccomp(cmd, map[string]SeccompProfile{DefaultSeccompProfile: defaultSeccompProfile}, DefaultSeccompProfile))
+
+			err := seccompViolation(DefaultSeccompProfile, cmd.Run())
+			var violation *SeccompViolationError
+			assert.Equal(t, tt.expectViolation, errors.As(err, &violation), err)
+			if !tt.expectViolation {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
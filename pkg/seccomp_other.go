@@ -0,0 +1,44 @@
+//go:build !linux
+
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func seccompSupported() bool {
+	return false
+}
+
+func applySeccomp(cmd *exec.Cmd, profiles map[string]SeccompProfile, name string) error {
+	if name == "" {
+		return nil
+	}
+	return errors.New("seccomp profiles are only supported on Linux")
+}
+
+// RunSeccompExec is only supported on Linux
+func RunSeccompExec(args []string) error {
+	return errors.New("seccomp profiles are only supported on Linux")
+}
+
+func seccompViolation(profile string, err error) error {
+	return err
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// the size of the AES-256 key
+const secretKeySize = 32
+
+// secretBox encrypts the secrets at rest with AES-256-GCM, the name of the
+// owner is authenticated with them so that they can't be moved to another one
+type secretBox struct {
+	aead cipher.AEAD
+}
+
+// loadSecretBox reads the base64 encoded key from the file, a random key is
+// generated if the file does not exist
+func loadSecretBox(file string) (box *secretBox, err error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		key := make([]byte, secretKeySize)
+		if _, err = rand.Read(key); err != nil {
+			return
+		}
+		data = []byte(base64.StdEncoding.EncodeToString(key) + "\n")
+		err = writeNewFile(file, data)
+	}
+	if err != nil {
+		return
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != secretKeySize {
+		return nil, fmt.Errorf("invalid key file %s, it must be %d bytes in base64", file, secretKeySize)
+	}
+	var block cipher.Block
+	if block, err = aes.NewCipher(key); err != nil {
+		return
+	}
+	box = &secretBox{}
+	box.aead, err = cipher.NewGCM(block)
+	return
+}
+
+// writeNewFile writes a file which only the owner can read, it fails if the
+// file exists
+func writeNewFile(name string, data []byte) error {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	return errors.Join(err, file.Close())
+}
+
+// seal encrypts the value in JSON, the result is the nonce and the
+// ciphertext in base64
+func (b *secretBox) seal(owner string, value any) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b.aead.Seal(nonce, nonce, data, []byte(owner))), nil
+}
+
+// open decrypts the sealed value into the given one, an empty one is skipped
+func (b *secretBox) open(owner, sealed string, value any) error {
+	if sealed == "" {
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err == nil && len(data) < b.aead.NonceSize() {
+		err = errors.New("too short")
+	}
+	if err == nil {
+		nonce := data[:b.aead.NonceSize()]
+		data, err = b.aead.Open(nil, nonce, data[len(nonce):], []byte(owner))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decrypt the secret of %q, the key may have changed: %w", owner, err)
+	}
+	return json.Unmarshal(data, value)
+}
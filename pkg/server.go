@@ -18,6 +18,7 @@ package pkg
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/linuxsuren/api-testing/pkg/version"
 	"github.com/linuxsuren/atest-ext-store-terminal/ui"
@@ -28,7 +29,7 @@ import (
 
 type terminalExtension struct {
 	remote.UnimplementedLoaderServer
-	httpPort int
+	server *ExecServer
 }
 
 type RemoteServer interface {
@@ -42,9 +43,11 @@ type UIExtension interface {
 	GetMenus(ctx context.Context, empty *server.Empty) (*server.MenuList, error)
 }
 
-func NewRemoteServer(httpPort int) (server RemoteServer) {
+// NewRemoteServer creates the gRPC server of the extension, the suites are
+// kept and run by the exec server
+func NewRemoteServer(execServer *ExecServer) (server RemoteServer) {
 	server = &terminalExtension{
-		httpPort: httpPort,
+		server: execServer,
 	}
 	return
 }
@@ -81,15 +84,146 @@ func (s *terminalExtension) GetPageOfCSS(ctx context.Context, in *server.SimpleN
 func (s *terminalExtension) GetPageOfServer(ctx context.Context, in *server.SimpleName) (reply *server.CommonResult, err error) {
 	reply = &server.CommonResult{
 		Success: true,
-		Message: fmt.Sprintf("http://localhost:%d/extensionProxy/terminal", s.httpPort),
+		Message: fmt.Sprintf("http://localhost:%d/extensionProxy/terminal", s.server.Port()),
 	}
 	return
 }
 
 func (s *terminalExtension) Verify(ctx context.Context, in *server.Empty) (reply *server.ExtensionStatus, err error) {
+	failures := s.server.verifyReadiness()
 	reply = &server.ExtensionStatus{
-		Ready:   true,
+		Ready:   len(failures) == 0,
 		Version: version.GetVersion(),
+		Message: strings.Join(failures, "\n"),
 	}
 	return
 }
+
+func (s *terminalExtension) ListTestSuite(ctx context.Context, _ *server.Empty) (reply *remote.TestSuites, err error) {
+	var suites []*CommandSuite
+	if suites, err = s.server.suites.list(); err != nil {
+		return
+	}
+	reply = &remote.TestSuites{}
+	for _, suite := range suites {
+		reply.Data = append(reply.Data, suite.toGRPC(s.server.Port(), true))
+	}
+	return
+}
+
+func (s *terminalExtension) CreateTestSuite(ctx context.Context, in *remote.TestSuite) (reply *server.Empty, err error) {
+	reply = &server.Empty{}
+	err = s.server.suites.create(&CommandSuite{
+		Name: in.Name,
+		Env:  pairsToMap(in.Param),
+	})
+	return
+}
+
+func (s *terminalExtension) GetTestSuite(ctx context.Context, in *remote.TestSuite) (reply *remote.TestSuite, err error) {
+	var suite *CommandSuite
+	if suite, err = s.server.suites.get(in.Name); err == nil {
+		reply = suite.toGRPC(s.server.Port(), in.Full)
+	}
+	return
+}
+
+// UpdateTestSuite updates the env of the suite, the cases are updated one by one
+func (s *terminalExtension) UpdateTestSuite(ctx context.Context, in *remote.TestSuite) (reply *remote.TestSuite, err error) {
+	var suite *CommandSuite
+	if suite, err = s.server.suites.update(in.Name, func(suite *CommandSuite) error {
+		suite.Env = pairsToMap(in.Param)
+		return nil
+	}); err == nil {
+		reply = suite.toGRPC(s.server.Port(), false)
+	}
+	return
+}
+
+func (s *terminalExtension) DeleteTestSuite(ctx context.Context, in *remote.TestSuite) (reply *server.Empty, err error) {
+	reply = &server.Empty{}
+	err = s.server.suites.delete(in.Name)
+	return
+}
+
+func (s *terminalExtension) RenameTestSuite(ctx context.Context, in *server.TestSuiteDuplicate) (reply *server.HelloReply, err error) {
+	reply = &server.HelloReply{}
+	err = s.server.suites.rename(in.SourceSuiteName, in.TargetSuiteName)
+	return
+}
+
+func (s *terminalExtension) ListTestCases(ctx context.Context, in *remote.TestSuite) (reply *server.TestCases, err error) {
+	var suite *CommandSuite
+	if suite, err = s.server.suites.get(in.Name); err != nil {
+		return
+	}
+	reply = &server.TestCases{}
+	for _, item := range suite.Items {
+		reply.Data = append(reply.Data, item.toGRPC(s.server.Port(), suite.Name))
+	}
+	return
+}
+
+func (s *terminalExtension) CreateTestCase(ctx context.Context, in *server.TestCase) (reply *server.Empty, err error) {
+	reply = &server.Empty{}
+	var item CommandCase
+	if item, err = caseFromGRPC(in); err != nil {
+		return
+	}
+	_, err = s.server.suites.update(in.SuiteName, func(suite *CommandSuite) error {
+		if suite.indexOf(item.Name) >= 0 {
+			return fmt.Errorf("case %q already exists in suite %q", item.Name, suite.Name)
+		}
+		suite.Items = append(suite.Items, item)
+		return nil
+	})
+	return
+}
+
+func (s *terminalExtension) GetTestCase(ctx context.Context, in *server.TestCase) (reply *server.TestCase, err error) {
+	var suite *CommandSuite
+	var item CommandCase
+	if suite, err = s.server.suites.get(in.SuiteName); err == nil {
+		if item, err = suite.getCase(in.Name); err == nil {
+			reply = item.toGRPC(s.server.Port(), suite.Name)
+		}
+	}
+	return
+}
+
+func (s *terminalExtension) UpdateTestCase(ctx context.Context, in *server.TestCase) (reply *server.TestCase, err error) {
+	var item CommandCase
+	if item, err = caseFromGRPC(in); err != nil {
+		return
+	}
+	if _, err = s.server.suites.update(in.SuiteName, func(suite *CommandSuite) error {
+		index := suite.indexOf(item.Name)
+		if index < 0 {
+			return fmt.Errorf("case %q not found in suite %q", item.Name, suite.Name)
+		}
+		suite.Items[index] = item
+		return nil
+	}); err == nil {
+		reply = item.toGRPC(s.server.Port(), in.SuiteName)
+	}
+	return
+}
+
+func (s *terminalExtension) DeleteTestCase(ctx context.Context, in *server.TestCase) (reply *server.Empty, err error) {
+	reply = &server.Empty{}
+	_, err = s.server.suites.update(in.SuiteName, func(suite *CommandSuite) error {
+		index := suite.indexOf(in.Name)
+		if index < 0 {
+			return fmt.Errorf("case %q not found in suite %q", in.Name, suite.Name)
+		}
+		suite.Items = append(suite.Items[:index], suite.Items[index+1:]...)
+		return nil
+	})
+	return
+}
+
+func (s *terminalExtension) RenameTestCase(ctx context.Context, in *server.TestCaseDuplicate) (reply *server.HelloReply, err error) {
+	reply = &server.HelloReply{}
+	err = s.server.suites.moveCase(in.SourceSuiteName, in.SourceCaseName, in.TargetSuiteName, in.TargetCaseName)
+	return
+}
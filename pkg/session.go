@@ -0,0 +1,503 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// the size of a terminal until the client resizes it
+const (
+	defaultCols = 80
+	defaultRows = 24
+)
+
+// Session types
+const (
+	SessionTypePTY    = "pty"
+	SessionTypeStream = "stream"
+)
+
+// Session is a running terminal session, either a PTY attached through the
+// WebSocket endpoint or a streaming command
+type Session struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Profile   string    `json:"profile"`
+	Pid       int       `json:"pid"`
+	Created   time.Time `json:"created"`
+	Cols      int       `json:"cols"`
+	Rows      int       `json:"rows"`
+	Recording string    `json:"recording,omitempty"`
+	// Host is the remote host of an SSH session
+	Host string `json:"host,omitempty"`
+
+	manager  *SessionManager
+	cgroup   *cgroup
+	shell    PTY
+	recorder *Recorder
+	screen   *Screen
+	seccomp  string
+	log      *slog.Logger
+	metrics  *metrics
+	mutex    sync.Mutex
+}
+
+// errSessionExists means the ID of a new session is taken by a running one
+var errSessionExists = errors.New("the session ID is taken")
+
+// SessionManager manages the running sessions, the IDs of the sessions which
+// are not started yet are reserved with a nil session
+type SessionManager struct {
+	sessions map[string]*Session
+	mutex    sync.RWMutex
+}
+
+func newSessionManager() *SessionManager {
+	return &SessionManager{
+		sessions: make(map[string]*Session),
+	}
+}
+
+// newSession creates a session of the server, it's placed into its own
+// cgroup when the cgroup root is configured. The ID is generated if it's
+// empty, otherwise it must not be taken by another session.
+func (s *ExecServer) newSession(ctx context.Context, id, sessionType string, profile Profile) (session *Session, err error) {
+	if id == "" {
+		id = uuid.NewString()
+	}
+	if err = s.sessionManager.reserve(id); err != nil {
+		return
+	}
+	session = &Session{
+		ID:      id,
+		Type:    sessionType,
+		Profile: profile.Name,
+		Created: time.Now(),
+		Cols:    defaultCols,
+		Rows:    defaultRows,
+		manager: s.sessionManager,
+		screen:  NewScreen(defaultCols, defaultRows),
+		log:     loggerFrom(ctx).With("session_id", id, "session_type", sessionType),
+		metrics: s.metrics,
+	}
+	if config := s.config.get().Cgroup; config.Root != "" {
+		if session.cgroup, err = newCgroup(config.Root, "session-"+uuid.NewString(), profile.limits(config.Limits)); err != nil {
+			s.sessionManager.remove(id)
+			return nil, err
+		}
+	}
+	return
+}
+
+// startPTYSession starts the shell of the profile on a local PTY, or on a
+// remote host, which is registered as a new session. The caller closes the
+// shell and the session once it's done.
+func (s *ExecServer) startPTYSession(ctx context.Context, id string, profile Profile, seccompProfile string) (session *Session, err error) {
+	if seccompProfile == "" {
+		seccompProfile = profile.Seccomp
+	}
+	if session, err = s.newSession(ctx, id, SessionTypePTY, profile); err != nil {
+		err = fmt.Errorf("failed to create session: %w", err)
+		return
+	}
+	session.seccomp = seccompProfile
+	if profile.SSH != nil {
+		session.Host = profile.SSH.User + "@" + profile.SSH.address()
+		session.log = session.log.With("host", session.Host)
+	}
+
+	var backend Backend
+	if backend, err = newBackend(s.runner, profile, s.policies.get(), seccompProfile, session.prepare); err == nil {
+		session.shell, err = backend.Start(ctx, session.Cols, session.Rows)
+	}
+	if err != nil {
+		session.log.Error("failed to start the shell", "error", err)
+		_ = session.close()
+		return nil, err
+	}
+	session.register(session.shell.Pid())
+	return
+}
+
+// prepare makes the command start inside the cgroup of the session
+func (s *Session) prepare(cmd *exec.Cmd) {
+	if s.cgroup != nil {
+		s.cgroup.attach(cmd)
+	}
+}
+
+// register records the started process, and makes the session visible
+func (s *Session) register(pid int) {
+	s.Pid = pid
+	s.log = s.log.With("pid", s.Pid)
+	s.log.Info("session started", "profile", s.Profile)
+	s.manager.add(s)
+}
+
+// close kills all the processes of the session
+func (s *Session) close() (err error) {
+	s.manager.remove(s.ID)
+	if recordingErr := s.stopRecording(); recordingErr != nil {
+		s.log.Warn("failed to stop the recording", "error", recordingErr)
+	}
+	if s.cgroup != nil {
+		if err = s.cgroup.close(); err != nil {
+			s.log.Warn("failed to clean up the cgroup", "error", err)
+		}
+	}
+	s.log.Info("session closed")
+	return
+}
+
+// MarshalJSON encodes the session while holding its lock
+func (s *Session) MarshalJSON() ([]byte, error) {
+	type session Session
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return json.Marshal((*session)(s))
+}
+
+// startRecording starts recording the session into a new file of the directory
+func (s *Session) startRecording(dir string, input bool) (name string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.recorder != nil {
+		return s.recorder.Name, nil
+	}
+
+	if s.recorder, err = newRecorder(s, dir, s.Cols, s.Rows, input); err == nil {
+		name = s.recorder.Name
+		s.Recording = name
+	}
+	return
+}
+
+func (s *Session) stopRecording() (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.recorder != nil {
+		err = s.recorder.Close()
+		s.recorder = nil
+		s.Recording = ""
+	}
+	return
+}
+
+// output is called with everything the session writes to the client
+func (s *Session) output(data []byte) {
+	s.metrics.countBytes(s.Type, directionOut, len(data))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, _ = s.screen.Write(data)
+	if s.recorder != nil {
+		s.recorder.Output(data)
+	}
+}
+
+// input is called with everything the client writes to the session
+func (s *Session) input(data []byte) {
+	s.metrics.countBytes(s.Type, directionIn, len(data))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.recorder != nil {
+		s.recorder.Input(data)
+	}
+}
+
+// resize changes the window size of the PTY
+func (s *Session) resize(cols, rows int) (err error) {
+	if cols <= 0 || rows <= 0 || cols > 0xffff || rows > 0xffff {
+		return errors.New("invalid terminal size")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.shell == nil {
+		return errors.New("only PTY sessions can be resized")
+	}
+	if err = s.shell.Resize(cols, rows); err != nil {
+		return
+	}
+	s.Cols, s.Rows = cols, rows
+	s.screen.Resize(cols, rows)
+	if s.recorder != nil {
+		s.recorder.Resize(cols, rows)
+	}
+	return
+}
+
+// signal sends a signal to the shell of a PTY session
+func (s *Session) signal(name string) error {
+	if s.shell == nil {
+		return errors.New("only PTY sessions can be signaled")
+	}
+	return s.shell.Signal(strings.TrimPrefix(strings.ToUpper(name), "SIG"))
+}
+
+// reserve takes the ID for a new session
+func (m *SessionManager) reserve(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.sessions[id]; ok {
+		return fmt.Errorf("%w: %s", errSessionExists, id)
+	}
+	m.sessions[id] = nil
+	return nil
+}
+
+// taken tells whether the ID is used or reserved by a session
+func (m *SessionManager) taken(id string) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	_, ok := m.sessions[id]
+	return ok
+}
+
+func (m *SessionManager) add(session *Session) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sessions[session.ID] = session
+}
+
+func (m *SessionManager) remove(id string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.sessions, id)
+}
+
+func (m *SessionManager) get(id string) (session *Session, ok bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	session = m.sessions[id]
+	return session, session != nil
+}
+
+func (m *SessionManager) list() (sessions []*Session) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	sessions = make([]*Session, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		if session != nil {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Created.Before(sessions[j].Created)
+	})
+	return
+}
+
+// sessionStats is the response of the session stats endpoint
+type sessionStats struct {
+	Session *Session     `json:"session"`
+	Cgroup  *CgroupStats `json:"cgroup,omitempty"`
+}
+
+func (s *ExecServer) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(s.sessionManager.list())
+}
+
+func (s *ExecServer) handleSessionStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := s.sessionManager.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	resp := sessionStats{Session: session}
+	if session.cgroup != nil {
+		stats, err := session.cgroup.stats()
+		if err != nil {
+			http.Error(w, "failed to read cgroup stats: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.Cgroup = &stats
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+type recordingRequest struct {
+	Enabled bool `json:"enabled"`
+	Input   bool `json:"input"`
+}
+
+// handleSessionRecording starts or stops recording a running session
+func (s *ExecServer) handleSessionRecording(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := s.sessionManager.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	var req recordingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if req.Enabled {
+		recording := s.policies.get().recording
+		_, err = session.startRecording(recording.Dir, req.Input || recording.Input)
+	} else {
+		err = session.stopRecording()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(session)
+}
+
+type resizeRequest struct {
+	Cols int `json:"cols"`
+	Rows int `json:"rows"`
+}
+
+// handleSessionResize changes the window size of a PTY session
+func (s *ExecServer) handleSessionResize(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := s.sessionManager.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	var req resizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := session.resize(req.Cols, req.Rows); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(session)
+}
+
+type signalRequest struct {
+	// Signal is the name of the signal, for instance: INT or SIGINT
+	Signal string `json:"signal"`
+}
+
+// handleSessionSignal sends a signal to the shell of a PTY session
+func (s *ExecServer) handleSessionSignal(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := s.sessionManager.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	var req signalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := session.signal(req.Signal); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(session)
+}
+
+// snapshot formats
+const (
+	snapshotText = "text"
+	snapshotANSI = "ansi"
+	snapshotJSON = "json"
+)
+
+// handleSessionSnapshot returns the visible screen of a session as plain
+// text, ANSI escape sequences or JSON cells
+func (s *ExecServer) handleSessionSnapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := s.sessionManager.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", snapshotText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(session.screen.Text()))
+	case snapshotANSI:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(session.screen.ANSI()))
+	case snapshotJSON:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(session.screen.Snapshot(r.URL.Query().Get("cells") != "false"))
+	default:
+		http.Error(w, "unsupported format: "+format, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSession(t *testing.T) {
+	server := newTestServer(t, &FakeRunner{})
+	profile, err := server.getProfile("")
+	require.NoError(t, err)
+
+	first, err := server.newSession(context.Background(), "tab-1", SessionTypeStream, profile)
+	require.NoError(t, err)
+	first.register(1)
+
+	// the ID is taken before the session starts, and while it's running
+	_, err = server.newSession(context.Background(), "tab-1", SessionTypeStream, profile)
+	assert.True(t, errors.Is(err, errSessionExists), err)
+	recorder := httptest.NewRecorder()
+	server.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/extensionProxy/terminal/ws?id=tab-1", nil))
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+
+	other, err := server.newSession(context.Background(), "", SessionTypeStream, profile)
+	require.NoError(t, err)
+	assert.NotEmpty(t, other.ID)
+	_, ok := server.sessionManager.get(other.ID)
+	assert.False(t, ok, "the session is not visible before it starts")
+	assert.Len(t, server.sessionManager.list(), 1)
+	require.NoError(t, other.close())
+
+	// closing a session frees its ID
+	require.NoError(t, first.close())
+	second, err := server.newSession(context.Background(), "tab-1", SessionTypeStream, profile)
+	require.NoError(t, err)
+	require.NoError(t, second.close())
+}
@@ -0,0 +1,605 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/linuxsuren/api-testing/pkg/server"
+	"github.com/linuxsuren/api-testing/pkg/testing/remote"
+	"gopkg.in/yaml.v3"
+)
+
+const suiteExt = ".yaml"
+
+// the headers and the expected body fields which carry a command case in the
+// test case of atest
+const (
+	caseHeaderProfile = "Profile"
+	caseHeaderTimeout = "Timeout"
+	caseFieldExitCode = "exitCode"
+	caseFieldStdout   = "stdout"
+	caseFieldStderr   = "stderr"
+)
+
+// CommandSuite is a test suite of shell commands, the env is set for all of its cases
+type CommandSuite struct {
+	Name  string            `yaml:"name"`
+	Env   map[string]string `yaml:"env,omitempty"`
+	Items []CommandCase     `yaml:"items"`
+}
+
+// caseResult is the response of running a case. Each output carries the
+// values of its matchers which passed, so that the expected body fields of
+// the test case, like stdout.contains, are found in it.
+type caseResult struct {
+	ExitCode int        `json:"exitCode"`
+	Stdout   caseOutput `json:"stdout"`
+	Stderr   caseOutput `json:"stderr"`
+	Failures []string   `json:"failures"`
+}
+
+// caseOutput is an output of the command and its matchers which passed
+type caseOutput struct {
+	Output   string `json:"output"`
+	Contains string `json:"contains,omitempty"`
+	Regex    string `json:"regex,omitempty"`
+	Equals   string `json:"equals,omitempty"`
+}
+
+// CommandCase is a shell command which is checked by its exit code and output
+type CommandCase struct {
+	Name     string    `yaml:"name"`
+	Command  string    `yaml:"command"`
+	Profile  string    `yaml:"profile,omitempty"`
+	Timeout  string    `yaml:"timeout,omitempty"`
+	ExitCode int       `yaml:"exitCode"`
+	Stdout   []Matcher `yaml:"stdout,omitempty"`
+	Stderr   []Matcher `yaml:"stderr,omitempty"`
+}
+
+// Matcher checks the output, only one of its fields is expected
+type Matcher struct {
+	Contains string `yaml:"contains,omitempty"`
+	Regex    string `yaml:"regex,omitempty"`
+	Equals   string `yaml:"equals,omitempty"`
+}
+
+// suiteStore keeps each suite in a YAML file of the directory
+type suiteStore struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// newSuiteStore creates the store of the command suites in the directory,
+// the user config directory is used when it is empty
+func newSuiteStore(dir string) (store *suiteStore, err error) {
+	if dir == "" {
+		if dir, err = os.UserConfigDir(); err != nil {
+			return
+		}
+		dir = filepath.Join(dir, "atest", "terminal", "suites")
+	}
+	if err = os.MkdirAll(dir, 0o700); err == nil {
+		store = &suiteStore{dir: dir}
+	}
+	return
+}
+
+func validateName(kind, name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid %s name: %q", kind, name)
+	}
+	return nil
+}
+
+func (s *suiteStore) path(name string) string {
+	return filepath.Join(s.dir, name+suiteExt)
+}
+
+func (s *suiteStore) load(name string) (suite *CommandSuite, err error) {
+	if err = validateName("suite", name); err != nil {
+		return
+	}
+	var data []byte
+	if data, err = os.ReadFile(s.path(name)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("suite %q not found", name)
+		}
+		return
+	}
+	suite = &CommandSuite{}
+	if err = yaml.Unmarshal(data, suite); err == nil {
+		suite.Name = name
+	}
+	return
+}
+
+// save writes the suite into a temporary file first, so that it's never
+// left half written
+func (s *suiteStore) save(suite *CommandSuite) (err error) {
+	if s.dir == "" {
+		return errors.New("the suite store is not configured")
+	}
+	var data []byte
+	if data, err = yaml.Marshal(suite); err != nil {
+		return
+	}
+	tmp := s.path(suite.Name) + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err == nil {
+		err = os.Rename(tmp, s.path(suite.Name))
+	}
+	return
+}
+
+func (s *suiteStore) exists(name string) bool {
+	_, err := os.Stat(s.path(name))
+	return err == nil
+}
+
+func (s *suiteStore) list() (suites []*CommandSuite, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var files []string
+	if files, err = filepath.Glob(filepath.Join(s.dir, "*"+suiteExt)); err != nil {
+		return
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		var suite *CommandSuite
+		if suite, err = s.load(strings.TrimSuffix(filepath.Base(file), suiteExt)); err != nil {
+			return
+		}
+		suites = append(suites, suite)
+	}
+	return
+}
+
+func (s *suiteStore) get(name string) (*CommandSuite, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.load(name)
+}
+
+func (s *suiteStore) create(suite *CommandSuite) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err = validateName("suite", suite.Name); err == nil {
+		if s.exists(suite.Name) {
+			err = fmt.Errorf("suite %q already exists", suite.Name)
+		} else {
+			err = s.save(suite)
+		}
+	}
+	return
+}
+
+// update changes a suite with the given function while holding the lock
+func (s *suiteStore) update(name string, change func(*CommandSuite) error) (suite *CommandSuite, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if suite, err = s.load(name); err == nil {
+		if err = change(suite); err == nil {
+			err = s.save(suite)
+		}
+	}
+	return
+}
+
+func (s *suiteStore) delete(name string) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err = validateName("suite", name); err == nil {
+		if err = os.Remove(s.path(name)); errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("suite %q not found", name)
+		}
+	}
+	return
+}
+
+func (s *suiteStore) rename(oldName, newName string) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var suite *CommandSuite
+	if suite, err = s.load(oldName); err != nil {
+		return
+	}
+	if err = validateName("suite", newName); err != nil {
+		return
+	}
+	if s.exists(newName) {
+		return fmt.Errorf("suite %q already exists", newName)
+	}
+	suite.Name = newName
+	if err = s.save(suite); err == nil {
+		err = os.Remove(s.path(oldName))
+	}
+	return
+}
+
+// moveCase moves or renames a case, within a suite or into another one
+func (s *suiteStore) moveCase(sourceSuite, sourceCase, targetSuite, targetCase string) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if targetSuite == "" {
+		targetSuite = sourceSuite
+	}
+
+	var source, target *CommandSuite
+	if source, err = s.load(sourceSuite); err != nil {
+		return
+	}
+	target = source
+	if targetSuite != sourceSuite {
+		if target, err = s.load(targetSuite); err != nil {
+			return
+		}
+	}
+
+	index := source.indexOf(sourceCase)
+	if index < 0 {
+		return fmt.Errorf("case %q not found in suite %q", sourceCase, sourceSuite)
+	}
+	if err = validateName("case", targetCase); err != nil {
+		return
+	}
+	if target.indexOf(targetCase) >= 0 {
+		return fmt.Errorf("case %q already exists in suite %q", targetCase, targetSuite)
+	}
+
+	if target == source {
+		source.Items[index].Name = targetCase
+		return s.save(source)
+	}
+	item := source.Items[index]
+	item.Name = targetCase
+	source.Items = append(source.Items[:index], source.Items[index+1:]...)
+	target.Items = append(target.Items, item)
+	if err = s.save(target); err == nil {
+		err = s.save(source)
+	}
+	return
+}
+
+func (s *CommandSuite) indexOf(name string) int {
+	for i, item := range s.Items {
+		if item.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// getCase returns the case of the suite by its name
+func (s *CommandSuite) getCase(name string) (item CommandCase, err error) {
+	if index := s.indexOf(name); index >= 0 {
+		item = s.Items[index]
+	} else {
+		err = fmt.Errorf("case %q not found in suite %q", name, s.Name)
+	}
+	return
+}
+
+// validate checks a case before it's saved
+func (c CommandCase) validate() (err error) {
+	if err = validateName("case", c.Name); err != nil {
+		return
+	}
+	if strings.TrimSpace(c.Command) == "" {
+		return fmt.Errorf("case %q has no command", c.Name)
+	}
+	if _, err = parseTimeout(c.Timeout, execTimeout); err != nil {
+		return
+	}
+	for _, matcher := range append(append([]Matcher{}, c.Stdout...), c.Stderr...) {
+		if _, err = matcher.kind(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// kind returns the kind and the value of the matcher
+func (m Matcher) kind() (kind string, err error) {
+	set := 0
+	for _, field := range []struct{ name, value string }{{"contains", m.Contains}, {"regex", m.Regex}, {"equals", m.Equals}} {
+		if field.value != "" {
+			kind = field.name
+			set++
+		}
+	}
+	switch {
+	case set != 1:
+		err = errors.New("a matcher needs exactly one of contains, regex and equals")
+	case m.Regex != "":
+		_, err = regexp.Compile(m.Regex)
+	}
+	return
+}
+
+func (m Matcher) value() string {
+	return m.Contains + m.Regex + m.Equals
+}
+
+// match returns an error describing why the output doesn't match
+func (m Matcher) match(output string) error {
+	switch kind, _ := m.kind(); kind {
+	case "contains":
+		if !strings.Contains(output, m.Contains) {
+			return fmt.Errorf("does not contain %q", m.Contains)
+		}
+	case "regex":
+		if !regexp.MustCompile(m.Regex).MatchString(output) {
+			return fmt.Errorf("does not match %q", m.Regex)
+		}
+	case "equals":
+		if strings.TrimRight(output, "\n") != strings.TrimRight(m.Equals, "\n") {
+			return fmt.Errorf("is not %q", m.Equals)
+		}
+	}
+	return nil
+}
+
+// runCase executes the case and returns its result, no failure means it passed
+func (s *ExecServer) runCase(ctx context.Context, c CommandCase, env map[string]string) (result caseResult, err error) {
+	profile, err := s.getProfile(c.Profile)
+	if err != nil {
+		return
+	}
+	timeout, err := parseTimeout(c.Timeout, execTimeout)
+	if err != nil {
+		return
+	}
+	req := execRequest{Cmd: c.Command, Profile: c.Profile}
+	req.withProfile(profile)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd, _, err := prepareCommand(ctx, s.runner, s.policies.get(), req, profile)
+	if err != nil {
+		return
+	}
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	resp := s.runCommand(cmd, req)
+
+	result = caseResult{
+		ExitCode: resp.ExitCode,
+		Stdout:   caseOutput{Output: resp.Stdout},
+		Stderr:   caseOutput{Output: resp.Stderr},
+		Failures: []string{},
+	}
+	if ctx.Err() != nil {
+		result.Failures = append(result.Failures, fmt.Sprintf("timed out after %s", timeout))
+	}
+	if resp.ExitCode != c.ExitCode {
+		result.Failures = append(result.Failures, fmt.Sprintf("exit code is %d, expected %d", resp.ExitCode, c.ExitCode))
+	}
+	for _, output := range []struct {
+		name     string
+		output   *caseOutput
+		matchers []Matcher
+	}{{caseFieldStdout, &result.Stdout, c.Stdout}, {caseFieldStderr, &result.Stderr, c.Stderr}} {
+		for _, matcher := range output.matchers {
+			if err := matcher.match(output.output.Output); err != nil {
+				result.Failures = append(result.Failures, output.name+" "+err.Error())
+				continue
+			}
+			switch kind, _ := matcher.kind(); kind {
+			case "contains":
+				output.output.Contains = matcher.Contains
+			case "regex":
+				output.output.Regex = matcher.Regex
+			case "equals":
+				output.output.Equals = matcher.Equals
+			}
+		}
+	}
+	return
+}
+
+// runURL is the endpoint which runs the case, it's the API of the test case
+// so that atest runs it like an HTTP test
+func runURL(port int, suite, name string) string {
+	return fmt.Sprintf("http://localhost:%d/api/suites/%s/cases/%s/run", port, url.PathEscape(suite), url.PathEscape(name))
+}
+
+// toGRPC converts the suite into the test suite of atest
+func (s *CommandSuite) toGRPC(port int, full bool) (suite *remote.TestSuite) {
+	suite = &remote.TestSuite{
+		Name:  s.Name,
+		Param: mapToPairs(s.Env),
+		Full:  full,
+	}
+	if full {
+		for _, item := range s.Items {
+			suite.Items = append(suite.Items, item.toGRPC(port, s.Name))
+		}
+	}
+	return
+}
+
+// toGRPC converts the case into the test case of atest. The command is the
+// body of the request, the profile and the timeout are its headers, and the
+// expectations are the expected body fields, like stdout.contains.
+func (c CommandCase) toGRPC(port int, suite string) *server.TestCase {
+	testCase := &server.TestCase{
+		Name:      c.Name,
+		SuiteName: suite,
+		Request: &server.Request{
+			Api:    runURL(port, suite, c.Name),
+			Method: http.MethodPost,
+			Body:   c.Command,
+		},
+		Response: &server.Response{
+			StatusCode: http.StatusOK,
+			BodyFieldsExpect: []*server.Pair{
+				{Key: caseFieldExitCode, Value: strconv.Itoa(c.ExitCode)},
+			},
+		},
+	}
+	for _, header := range []*server.Pair{{Key: caseHeaderProfile, Value: c.Profile}, {Key: caseHeaderTimeout, Value: c.Timeout}} {
+		if header.Value != "" {
+			testCase.Request.Header = append(testCase.Request.Header, header)
+		}
+	}
+	for _, output := range []struct {
+		name     string
+		matchers []Matcher
+	}{{caseFieldStdout, c.Stdout}, {caseFieldStderr, c.Stderr}} {
+		for _, matcher := range output.matchers {
+			kind, _ := matcher.kind()
+			testCase.Response.BodyFieldsExpect = append(testCase.Response.BodyFieldsExpect,
+				&server.Pair{Key: output.name + "." + kind, Value: matcher.value()})
+		}
+	}
+	return testCase
+}
+
+// caseFromGRPC converts the test case of atest back into a command case
+func caseFromGRPC(testCase *server.TestCase) (item CommandCase, err error) {
+	item.Name = testCase.GetName()
+	item.Command = testCase.GetRequest().GetBody()
+	for _, header := range testCase.GetRequest().GetHeader() {
+		switch {
+		case strings.EqualFold(header.Key, caseHeaderProfile):
+			item.Profile = header.Value
+		case strings.EqualFold(header.Key, caseHeaderTimeout):
+			item.Timeout = header.Value
+		}
+	}
+	for _, field := range testCase.GetResponse().GetBodyFieldsExpect() {
+		if field.Key == caseFieldExitCode {
+			if item.ExitCode, err = strconv.Atoi(field.Value); err != nil {
+				return item, fmt.Errorf("invalid exit code: %q", field.Value)
+			}
+			continue
+		}
+
+		output, kind, _ := strings.Cut(field.Key, ".")
+		var matcher Matcher
+		switch kind {
+		case "contains":
+			matcher.Contains = field.Value
+		case "regex":
+			matcher.Regex = field.Value
+		case "equals":
+			matcher.Equals = field.Value
+		default:
+			return item, fmt.Errorf("unknown expectation: %q", field.Key)
+		}
+		switch output {
+		case caseFieldStdout:
+			item.Stdout = append(item.Stdout, matcher)
+		case caseFieldStderr:
+			item.Stderr = append(item.Stderr, matcher)
+		default:
+			return item, fmt.Errorf("unknown expectation: %q", field.Key)
+		}
+	}
+	err = item.validate()
+	return
+}
+
+func mapToPairs(data map[string]string) (pairs []*server.Pair) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pairs = append(pairs, &server.Pair{Key: key, Value: data[key]})
+	}
+	return
+}
+
+func pairsToMap(pairs []*server.Pair) (data map[string]string) {
+	for _, pair := range pairs {
+		if pair.Key == "" {
+			continue
+		}
+		if data == nil {
+			data = map[string]string{}
+		}
+		data[pair.Key] = pair.Value
+	}
+	return
+}
+
+// handleRunCase runs a case of a suite. It responds with 200 when the case
+// passes, and 417 otherwise, the result carries the failures.
+func (s *ExecServer) handleRunCase(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	suite, err := s.suites.get(r.PathValue("suite"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	item, err := suite.getCase(r.PathValue("case"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	release, err := s.commandLimiter.acquire(r.Context(), requestUser(r), nil)
+	if errors.Is(err, errQueueFull) {
+		loggerFrom(r.Context()).Warn("case rejected", "error", err)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	} else if err != nil {
+		// the client is gone while waiting
+		return
+	}
+	defer release()
+
+	done, err := s.startSession()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer done()
+
+	result, err := s.runCase(s.ctx, item, suite.Env)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(result.Failures) > 0 {
+		w.WriteHeader(http.StatusExpectationFailed)
+	}
+	_ = json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestHandleRunCase(t *testing.T) {
+	item := CommandCase{
+		Name:     "version",
+		Command:  "go version",
+		ExitCode: 0,
+		Stdout:   []Matcher{{Contains: "go version"}, {Regex: `go1\.\d+`}},
+	}
+
+	tests := []struct {
+		name           string
+		runner         *FakeRunner
+		expectStatus   int
+		expectFailures []string
+	}{{
+		name:           "passed",
+		runner:         &FakeRunner{ExpectStdout: "go version go1.24.3 linux/amd64\n"},
+		expectStatus:   http.StatusOK,
+		expectFailures: []string{},
+	}, {
+		name:         "failed",
+		runner:       &FakeRunner{ExpectStdout: "go: not found\n", ExpectExitCode: 127},
+		expectStatus: http.StatusExpectationFailed,
+		expectFailures: []string{
+			"exit code is 127, expected 0",
+			`stdout does not contain "go version"`,
+			`stdout does not match "go1\\.\\d+"`,
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, tt.runner)
+			require.NoError(t, server.suites.create(&CommandSuite{Name: "smoke", Items: []CommandCase{item}}))
+
+			recorder := httptest.NewRecorder()
+			server.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/suites/smoke/cases/version/run", nil))
+			assert.Equal(t, tt.expectStatus, recorder.Code)
+			assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+			var result caseResult
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+			assert.Equal(t, tt.expectFailures, result.Failures)
+			assert.Equal(t, tt.runner.ExpectStdout, result.Stdout.Output)
+
+			// atest finds the expected body fields of the test case in the result
+			body := recorder.Body.String()
+			for _, field := range item.toGRPC(server.Port(), "smoke").Response.BodyFieldsExpect {
+				value := gjson.Get(body, field.Key)
+				if tt.expectStatus == http.StatusOK {
+					assert.Equal(t, field.Value, value.String(), field.Key)
+				} else {
+					assert.NotEqual(t, field.Value, value.String(), field.Key)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleRunCaseShuttingDown(t *testing.T) {
+	server := newTestServer(t, &FakeRunner{})
+	require.NoError(t, server.suites.create(&CommandSuite{Name: "smoke", Items: []CommandCase{{Name: "version", Command: "go version"}}}))
+	require.NoError(t, server.Shutdown(context.Background()))
+
+	recorder := httptest.NewRecorder()
+	server.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/suites/smoke/cases/version/run", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+}
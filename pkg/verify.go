@@ -0,0 +1,148 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// the timeout of connecting to the exec server when it's verified
+const verifyDialTimeout = time.Second
+
+// readinessCheck is one of the checks of Verify
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// readinessChecks are the checks of the exec server, the profiles, the
+// directories and the limits
+func (s *ExecServer) readinessChecks() (checks []readinessCheck) {
+	checks = append(checks, readinessCheck{name: "exec server", check: func() error {
+		network, address := s.dialAddress()
+		conn, err := net.DialTimeout(network, address, verifyDialTimeout)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err
+	}})
+	p := s.policies.get()
+	for _, profile := range p.profiles {
+		checks = append(checks, readinessCheck{name: "profile " + profile.Name, check: func() error { return profile.verify(s.runner) }})
+	}
+	if runtime.GOOS != "windows" {
+		checks = append(checks, readinessCheck{name: "pty", check: checkPTY})
+	}
+
+	s.jobs.mutex.RLock()
+	jobDir := s.jobs.config.Dir
+	s.jobs.mutex.RUnlock()
+	dirs := []struct{ name, dir string }{
+		{"recording directory", p.recording.Dir},
+		{"job directory", jobDir},
+		{"store directory", s.suites.dir},
+		// a sub-group is created for each session, like a directory
+		{"cgroup root", s.config.get().Cgroup.Root},
+	}
+	for _, item := range dirs {
+		if item.dir != "" {
+			dir := item.dir
+			checks = append(checks, readinessCheck{name: item.name, check: func() error { return checkWritable(dir) }})
+		}
+	}
+	checks = append(checks, readinessCheck{name: "limits", check: p.limits.validate})
+	return
+}
+
+// dialAddress is the address of the listener, or localhost when it listens
+// on all the addresses
+func (s *ExecServer) dialAddress() (network, address string) {
+	addr := s.listener.Addr()
+	network, address = addr.Network(), addr.String()
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok && (tcpAddr.IP == nil || tcpAddr.IP.IsUnspecified()) {
+		address = net.JoinHostPort("localhost", strconv.Itoa(tcpAddr.Port))
+	}
+	return
+}
+
+// verifyReadiness runs the checks, and returns a message for each failing one
+func (s *ExecServer) verifyReadiness() (failures []string) {
+	for _, item := range s.readinessChecks() {
+		if err := item.check(); err != nil {
+			s.logger.Warn("readiness check failed", "check", item.name, "error", err)
+			failures = append(failures, fmt.Sprintf("%s: %v", item.name, err))
+		}
+	}
+	return
+}
+
+// verify checks that the command of the profile can be found by the runner
+func (p Profile) verify(runner CommandRunner) (err error) {
+	if p.SSH != nil {
+		return p.SSH.verify()
+	}
+	command := p.Command
+	if command == "" {
+		command = defaultShell()
+	}
+	if _, err = runner.LookPath(command); err != nil {
+		return
+	}
+	if p.Dir != "" {
+		var dir string
+		if dir, err = expandHome(p.Dir); err != nil {
+			return
+		}
+		var info os.FileInfo
+		if info, err = os.Stat(dir); err == nil && !info.IsDir() {
+			err = fmt.Errorf("%s is not a directory", dir)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if p.Seccomp != "" && !seccompSupported() {
+		err = errors.New("seccomp is not supported on this server")
+	}
+	return
+}
+
+// checkPTY opens and closes a PTY
+func checkPTY() error {
+	ptmx, tty, err := pty.Open()
+	if err == nil {
+		_ = tty.Close()
+		_ = ptmx.Close()
+	}
+	return err
+}
+
+// checkWritable creates and removes a directory in dir
+func checkWritable(dir string) error {
+	tmp, err := os.MkdirTemp(dir, ".verify-")
+	if err == nil {
+		err = os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2025 API Testing Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package pkg
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecServerReadiness(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		address func(t *testing.T) string
+	}{{
+		name:    "loopback address",
+		network: "tcp",
+		address: func(*testing.T) string { return "127.0.0.2:0" },
+	}, {
+		name:    "all addresses",
+		network: "tcp",
+		address: func(*testing.T) string { return ":0" },
+	}, {
+		name:    "unix socket",
+		network: "unix",
+		address: func(t *testing.T) string { return filepath.Join(t.TempDir(), "exec.sock") },
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, &FakeRunner{})
+			listener, err := net.Listen(tt.network, tt.address(t))
+			if err != nil {
+				t.Skip(err)
+			}
+			defer listener.Close()
+			_ = server.listener.Close()
+			server.listener = listener
+
+			check := server.readinessChecks()[0]
+			assert.Equal(t, "exec server", check.name)
+			require.NoError(t, check.check())
+		})
+	}
+}
@@ -3,13 +3,14 @@ package pkg
 import (
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 )
 
 func writeAndFlush(writer io.Writer, format string, a ...any) {
 	_, e := fmt.Fprintf(writer, format, a...)
 	if e != nil {
-		fmt.Println("failed to write to terminal", "stdout:", e)
+		slog.Warn("failed to write to terminal", "error", e)
 	} else {
 		if flush, ok := writer.(http.Flusher); ok {
 			flush.Flush()